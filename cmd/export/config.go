@@ -0,0 +1,40 @@
+package export
+
+import (
+	"github.com/axiomhq/sentinelexport/pkg/config"
+	"github.com/spf13/viper"
+)
+
+// loadConfig reads the --config file, if there is one. Its settings are used as
+// defaults, so flags and environment variables take precedence over them.
+func loadConfig() (*config.Config, error) {
+	configFile = viper.GetString("CONFIG")
+	if configFile == "" {
+		return nil, nil
+	}
+
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, value := range map[string]string{
+		"STORAGE_URL":          cfg.Storage.URL,
+		"CONNECTION_STRING":    cfg.Storage.ConnectionString,
+		"EVENTS_QUEUE":         cfg.Storage.EventsQueue,
+		"QUEUE_URL":            cfg.Storage.QueueURL,
+		"AXIOM_URL":            cfg.Axiom.URL,
+		"AXIOM_TOKEN":          cfg.Axiom.Token,
+		"AXIOM_ORG":            cfg.Axiom.Org,
+		"AXIOM_DATASET_PREFIX": cfg.Axiom.DatasetPrefix,
+	} {
+		if value != "" {
+			viper.SetDefault(key, value)
+		}
+	}
+	if cfg.Axiom.NoCreateDatasets {
+		viper.SetDefault("NO_CREATE_DATASETS", true)
+	}
+
+	return cfg, nil
+}
@@ -3,21 +3,36 @@ package export
 import (
 	"context"
 	"fmt"
+	"io"
+	"net/http"
 	"os"
 	"os/signal"
+	"slices"
 	"strings"
 	"syscall"
+	"text/tabwriter"
+	"time"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/policy"
 	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azqueue"
 	"github.com/axiomhq/axiom-go/axiom"
 	"github.com/axiomhq/sentinelexport/pkg/axm"
+	"github.com/axiomhq/sentinelexport/pkg/config"
+	"github.com/axiomhq/sentinelexport/pkg/logging"
 	"github.com/axiomhq/sentinelexport/pkg/monitor"
 	"github.com/axiomhq/sentinelexport/pkg/poll"
+	"github.com/axiomhq/sentinelexport/pkg/sink"
+	"github.com/axiomhq/sentinelexport/pkg/source"
+	"github.com/axiomhq/sentinelexport/pkg/tracing"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 )
 
+var logger = logging.New("export")
+
 var Cmd = &cobra.Command{
 	Use:   "export",
 	Short: "exports data from azure log anaytics (via storage) to axiom",
@@ -31,8 +46,26 @@ var Cmd = &cobra.Command{
 	Run: export,
 }
 
+// azureTransport traces the requests of the azure clients, it is only set when
+// tracing.
+var azureTransport policy.Transporter
+
+func blobOptions() *azblob.ClientOptions {
+	if azureTransport == nil {
+		return nil
+	}
+	return &azblob.ClientOptions{ClientOptions: azcore.ClientOptions{Transport: azureTransport}}
+}
+
+func queueOptions() *azqueue.ClientOptions {
+	if azureTransport == nil {
+		return nil
+	}
+	return &azqueue.ClientOptions{ClientOptions: azcore.ClientOptions{Transport: azureTransport}}
+}
+
 func authConnectionString(ctx context.Context, connectionString string) (*azblob.Client, error) {
-	return azblob.NewClientFromConnectionString(connectionString, nil)
+	return azblob.NewClientFromConnectionString(connectionString, blobOptions())
 }
 
 func authDefualt(ctx context.Context, serviceURL string) (*azblob.Client, error) {
@@ -41,55 +74,256 @@ func authDefualt(ctx context.Context, serviceURL string) (*azblob.Client, error)
 		return nil, fmt.Errorf("error getting default azure credentials: %w", err)
 	}
 
-	return azblob.NewClient(serviceURL, cred, nil)
+	return azblob.NewClient(serviceURL, cred, blobOptions())
+}
+
+func authQueueConnectionString(ctx context.Context, connectionString, queueName string) (*azqueue.QueueClient, error) {
+	return azqueue.NewQueueClientFromConnectionString(connectionString, queueName, queueOptions())
+}
+
+func authQueueDefault(ctx context.Context, queueServiceURL, queueName string) (*azqueue.QueueClient, error) {
+	cred, err := azidentity.NewDefaultAzureCredential(nil)
+	if err != nil {
+		return nil, fmt.Errorf("error getting default azure credentials: %w", err)
+	}
+
+	return azqueue.NewQueueClient(strings.TrimSuffix(queueServiceURL, "/")+"/"+queueName, cred, queueOptions())
 }
 
 var (
 	storageURL          string
+	sourceDir           string
 	connectionString    string
+	axiomToken          string
 	axiomPersonalAPIKey string
 	axiomPersonalOrg    string
 	axiomDatasetPrefix  string
 
 	axiomURL string
 
+	configFile string
+
+	includeTables string
+	excludeTables string
+
+	once   bool
+	dryRun bool
+
+	sinkNames string
+	sinkDir   string
+
+	auditDataset string
+
+	logLevel  string
+	logFormat string
+
+	httpAddr       string
+	livenessWindow time.Duration
+
+	otlpEndpoint string
+
+	eventsQueue       string
+	queueURL          string
+	reconcileInterval time.Duration
+
+	retainBlobs         bool
+	tailBlobs           bool
+	checkpointContainer string
+
+	settleGrace time.Duration
+	settleQuiet time.Duration
+
+	staleAfter time.Duration
+
+	failurePolicy       string
+	deadLetterContainer string
+	maxAttempts         int
+
+	ingestRetries int
+	ingestBackoff time.Duration
+
+	noCreateDatasets bool
+
 	workerPoolSize int
 )
 
 func init() {
-	// TODO: we shouldn't be using personal tokens, API token work will allow us to use api tokens in the future
 	viper.AutomaticEnv()
 
 	flags := Cmd.Flags()
+	flags.StringVar(&configFile, "config", "", "path to a yaml config file, e.g. sentinel-sync.yaml, for per table settings; flags and environment variables take precedence over it (or env CONFIG)")
+	if err := viper.BindPFlag("CONFIG", flags.Lookup("config")); err != nil {
+		panic(err)
+	}
+	flags.StringVar(&includeTables, "include-tables", "", "comma separated globs, or /regexes/, of the tables to export; all tables by default (or env INCLUDE_TABLES)")
+	if err := viper.BindPFlag("INCLUDE_TABLES", flags.Lookup("include-tables")); err != nil {
+		panic(err)
+	}
+	flags.StringVar(&excludeTables, "exclude-tables", "", "comma separated globs, or /regexes/, of the tables not to export, even if included (or env EXCLUDE_TABLES)")
+	if err := viper.BindPFlag("EXCLUDE_TABLES", flags.Lookup("exclude-tables")); err != nil {
+		panic(err)
+	}
+	flags.BoolVar(&once, "once", false, "export every blob that has settled and exit, rather than running until stopped; exits non-zero if anything failed (or env ONCE)")
+	if err := viper.BindPFlag("ONCE", flags.Lookup("once")); err != nil {
+		panic(err)
+	}
+	flags.BoolVar(&dryRun, "dry-run", false, "read and check the blobs that would be exported, and report what would be sent to which dataset, without sending or deleting anything; implies --once (or env DRY_RUN)")
+	if err := viper.BindPFlag("DRY_RUN", flags.Lookup("dry-run")); err != nil {
+		panic(err)
+	}
+	flags.StringVar(&sinkNames, "sink", "axiom", "comma separated sinks to write the rows to, in order: axiom, file (newline delimited json files in --sink-dir) or stdout (or env SINK)")
+	if err := viper.BindPFlag("SINK", flags.Lookup("sink")); err != nil {
+		panic(err)
+	}
+	flags.StringVar(&sinkDir, "sink-dir", "export", "the directory the file sink writes to, one file per dataset and day (or env SINK_DIR)")
+	if err := viper.BindPFlag("SINK_DIR", flags.Lookup("sink-dir")); err != nil {
+		panic(err)
+	}
+	flags.StringVar(&auditDataset, "audit-dataset", "", "the axiom dataset to write an audit event to for every blob shipped, failed or dead-lettered, e.g. sentinel-sync-audit; not prefixed with --axiom-dataset-prefix (or env AUDIT_DATASET)")
+	if err := viper.BindPFlag("AUDIT_DATASET", flags.Lookup("audit-dataset")); err != nil {
+		panic(err)
+	}
+	flags.StringVar(&otlpEndpoint, "otlp-endpoint", "", "the url of an OTLP/HTTP collector to send traces to, e.g. http://localhost:4318; traces aren't sent by default (or env OTLP_ENDPOINT)")
+	if err := viper.BindPFlag("OTLP_ENDPOINT", flags.Lookup("otlp-endpoint")); err != nil {
+		panic(err)
+	}
+	flags.StringVar(&logLevel, "log-level", "info", "the level to log at: debug, info, warn or error (or env LOG_LEVEL)")
+	if err := viper.BindPFlag("LOG_LEVEL", flags.Lookup("log-level")); err != nil {
+		panic(err)
+	}
+	flags.StringVar(&logFormat, "log-format", "json", "how to log: json, or text for people to read (or env LOG_FORMAT)")
+	if err := viper.BindPFlag("LOG_FORMAT", flags.Lookup("log-format")); err != nil {
+		panic(err)
+	}
+	flags.StringVar(&httpAddr, "http-addr", "", "the address to serve prometheus metrics on at /metrics, and health checks at /healthz and /readyz, e.g. :9090; nothing is served when empty (or env HTTP_ADDR)")
+	if err := viper.BindPFlag("HTTP_ADDR", flags.Lookup("http-addr")); err != nil {
+		panic(err)
+	}
+	flags.DurationVar(&livenessWindow, "liveness-window", 0, "how long without a successful listing of the containers, or with only failed ingests, before /healthz fails; 0 is 5m, or twice --reconcile-interval with --events-queue (or env LIVENESS_WINDOW)")
+	if err := viper.BindPFlag("LIVENESS_WINDOW", flags.Lookup("liveness-window")); err != nil {
+		panic(err)
+	}
 	flags.IntVar(&workerPoolSize, "worker-pool-size", 8, "the size of the worker pool used to transfer blobs to axiom (more workers == more blobs sent concurrently)")
-	flags.StringVar(&axiomPersonalAPIKey, "axiom-personal-token", "", "your full axiom personal API key (or env AXIOM_PERSONAL_TOKEN)")
+	flags.StringVar(&axiomToken, "axiom-token", "", "your axiom API token, or a personal token along with --axiom-personal-org (or env AXIOM_TOKEN)")
+	if err := viper.BindPFlag("AXIOM_TOKEN", flags.Lookup("axiom-token")); err != nil {
+		panic(err)
+	}
+	flags.StringVar(&axiomPersonalAPIKey, "axiom-personal-token", "", "your full axiom personal API key, prefer --axiom-token (or env AXIOM_PERSONAL_TOKEN)")
 	if err := viper.BindPFlag("AXIOM_PERSONAL_TOKEN", flags.Lookup("axiom-personal-token")); err != nil {
 		panic(err)
 	}
 
-	flags.StringVar(&axiomPersonalOrg, "axiom-personal-org", "", "your axiom personal token org")
-	flags.StringVar(&axiomURL, "axiom-url", "https://api.axiom.co", "your axiom url")
+	flags.StringVar(&axiomPersonalOrg, "axiom-personal-org", "", "your axiom personal token org (or env AXIOM_ORG)")
+	if err := viper.BindPFlag("AXIOM_ORG", flags.Lookup("axiom-personal-org")); err != nil {
+		panic(err)
+	}
+	flags.StringVar(&axiomURL, "axiom-url", "https://api.axiom.co", "your axiom url (or env AXIOM_URL)")
+	if err := viper.BindPFlag("AXIOM_URL", flags.Lookup("axiom-url")); err != nil {
+		panic(err)
+	}
+	flags.IntVar(&ingestRetries, "ingest-retries", axm.DefaultRetryPolicy.MaxAttempts, "how many times to try ingesting a blob into axiom when it is rate limited or fails, before leaving it for the next cycle (or env INGEST_RETRIES)")
+	if err := viper.BindPFlag("INGEST_RETRIES", flags.Lookup("ingest-retries")); err != nil {
+		panic(err)
+	}
+	flags.DurationVar(&ingestBackoff, "ingest-backoff", axm.DefaultRetryPolicy.InitialInterval, "how long to back off after the first failed ingest, doubling after every failure (or env INGEST_BACKOFF)")
+	if err := viper.BindPFlag("INGEST_BACKOFF", flags.Lookup("ingest-backoff")); err != nil {
+		panic(err)
+	}
 
 	// TODO: more auth options around authing with a storage account are needed
-	flags.StringVar(&connectionString, "connection-string", "", "your azure storage account connection-string (or env CONNECTION_STRING)")
+	flags.StringVar(&connectionString, "connection-string", "", "your azure storage account connection-string, one storage account is exported per exporter (or env CONNECTION_STRING)")
 	if err := viper.BindPFlag("CONNECTION_STRING", flags.Lookup("connection-string")); err != nil {
 		panic(err)
 	}
-	flags.StringVar(&storageURL, "storage-url", "", "your azure storage account url; should be something like https://foobar.blob.core.windows.net/ (or env STORAGE_URL)")
+	flags.StringVar(&storageURL, "storage-url", "", "your azure storage account url, one storage account is exported per exporter; should be something like https://foobar.blob.core.windows.net/ (or env STORAGE_URL)")
 	if err := viper.BindPFlag("STORAGE_URL", flags.Lookup("storage-url")); err != nil {
 		panic(err)
 	}
 
+	flags.StringVar(&sourceDir, "source-dir", "", "read the blobs from a local directory with a sub directory per container, e.g. downloaded with azcopy, instead of the storage account (or env SOURCE_DIR)")
+	if err := viper.BindPFlag("SOURCE_DIR", flags.Lookup("source-dir")); err != nil {
+		panic(err)
+	}
+
 	flags.StringVar(&axiomDatasetPrefix, "axiom-dataset-prefix", "", "prefix to add to axiom dataset names")
 	if err := viper.BindPFlag("AXIOM_DATASET_PREFIX", flags.Lookup("axiom-dataset-prefix")); err != nil {
 		panic(err)
 	}
+
+	flags.BoolVar(&noCreateDatasets, "no-create-datasets", false, "don't create missing datasets in axiom, for tokens without permission to; blobs of tables without a dataset fail to export (or env NO_CREATE_DATASETS)")
+	if err := viper.BindPFlag("NO_CREATE_DATASETS", flags.Lookup("no-create-datasets")); err != nil {
+		panic(err)
+	}
+
+	flags.StringVar(&eventsQueue, "events-queue", "", "name of the storage queue an event grid subscription delivers blob created events to; enables event driven blob discovery (or env EVENTS_QUEUE)")
+	if err := viper.BindPFlag("EVENTS_QUEUE", flags.Lookup("events-queue")); err != nil {
+		panic(err)
+	}
+	flags.StringVar(&queueURL, "queue-url", "", "your azure storage account queue service url; defaults to the storage url with .blob. replaced by .queue. (or env QUEUE_URL)")
+	if err := viper.BindPFlag("QUEUE_URL", flags.Lookup("queue-url")); err != nil {
+		panic(err)
+	}
+	flags.DurationVar(&reconcileInterval, "reconcile-interval", 10*time.Minute, "how often to list all containers as a safety net when using --events-queue (or env RECONCILE_INTERVAL)")
+	if err := viper.BindPFlag("RECONCILE_INTERVAL", flags.Lookup("reconcile-interval")); err != nil {
+		panic(err)
+	}
+
+	flags.StringVar(&failurePolicy, "failure-policy", string(poll.FailureKeep), "what to do with a blob when axiom rejects some of its rows: keep (retry the blob later), deadletter (copy the rejected rows to the dead-letter container) or drop (or env FAILURE_POLICY)")
+	if err := viper.BindPFlag("FAILURE_POLICY", flags.Lookup("failure-policy")); err != nil {
+		panic(err)
+	}
+	flags.StringVar(&deadLetterContainer, "deadletter-container", "sentinel-sync-deadletter", "the container blobs that can't be exported, and rows rejected with --failure-policy=deadletter, are copied to (or env DEADLETTER_CONTAINER)")
+	if err := viper.BindPFlag("DEADLETTER_CONTAINER", flags.Lookup("deadletter-container")); err != nil {
+		panic(err)
+	}
+	flags.IntVar(&maxAttempts, "max-attempts", 5, "how many times exporting a blob may fail because of the blob itself, e.g. axiom can not parse it, before it is moved to the dead-letter container, 0 keeps retrying it forever (or env MAX_ATTEMPTS)")
+	if err := viper.BindPFlag("MAX_ATTEMPTS", flags.Lookup("max-attempts")); err != nil {
+		panic(err)
+	}
+
+	flags.BoolVar(&retainBlobs, "retain-blobs", false, "keep blobs after exporting them, tracking progress in checkpoint blobs instead (or env RETAIN_BLOBS)")
+	if err := viper.BindPFlag("RETAIN_BLOBS", flags.Lookup("retain-blobs")); err != nil {
+		panic(err)
+	}
+	flags.DurationVar(&settleGrace, "settle-grace", monitor.DefaultSettlePolicy.Grace, "how long after the end of a blob's 5 minute window before it is exported, for rows data export writes late (or env SETTLE_GRACE)")
+	if err := viper.BindPFlag("SETTLE_GRACE", flags.Lookup("settle-grace")); err != nil {
+		panic(err)
+	}
+	flags.DurationVar(&settleQuiet, "settle-quiet", monitor.DefaultSettlePolicy.Quiet, "how long an append blob must not have been modified before it is exported (or env SETTLE_QUIET)")
+	if err := viper.BindPFlag("SETTLE_QUIET", flags.Lookup("settle-quiet")); err != nil {
+		panic(err)
+	}
+
+	flags.DurationVar(&staleAfter, "stale-after", 24*time.Hour, "how long a container may go without a new blob before its table is flagged as stale, 0 to never flag tables (or env STALE_AFTER)")
+	if err := viper.BindPFlag("STALE_AFTER", flags.Lookup("stale-after")); err != nil {
+		panic(err)
+	}
+
+	flags.BoolVar(&tailBlobs, "tail-blobs", false, "export rows as they are appended to a blob instead of waiting for the blob to settle (or env TAIL_BLOBS)")
+	if err := viper.BindPFlag("TAIL_BLOBS", flags.Lookup("tail-blobs")); err != nil {
+		panic(err)
+	}
+	flags.StringVar(&checkpointContainer, "checkpoint-container", "sentinel-sync-checkpoints", "the container checkpoints are stored in when using --retain-blobs or --tail-blobs, and the end of the audit chain with --audit-dataset (or env CHECKPOINT_CONTAINER)")
+	if err := viper.BindPFlag("CHECKPOINT_CONTAINER", flags.Lookup("checkpoint-container")); err != nil {
+		panic(err)
+	}
 }
 
-func ensureValid(ctx context.Context) (*azblob.Client, error) {
-	axiomPersonalAPIKey = viper.Get("AXIOM_PERSONAL_TOKEN").(string)
-	if axiomPersonalAPIKey == "" {
-		return nil, fmt.Errorf("axiom personal token is required")
+func ensureValid(ctx context.Context) (source.Source, error) {
+	axiomToken = viper.GetString("AXIOM_TOKEN")
+	if axiomToken == "" {
+		axiomToken = viper.GetString("AXIOM_PERSONAL_TOKEN")
+	}
+
+	sourceDir = viper.GetString("SOURCE_DIR")
+	if sourceDir != "" {
+		if viper.GetString("EVENTS_QUEUE") != "" {
+			return nil, fmt.Errorf("events queue can not be used with a source directory")
+		}
+		if _, err := os.Stat(sourceDir); err != nil {
+			return nil, fmt.Errorf("can not read source directory: %w", err)
+		}
+		return source.NewDir(sourceDir), nil
 	}
 
 	storageURL = viper.Get("STORAGE_URL").(string)
@@ -103,52 +337,405 @@ func ensureValid(ctx context.Context) (*azblob.Client, error) {
 		if err != nil {
 			return nil, fmt.Errorf("can not auth with azure via connection-string: %w", err)
 		}
-		return azclient, nil
+		return source.NewAzure(azclient), nil
 	}
 
 	azclient, err := authDefualt(ctx, storageURL)
 	if err != nil {
 		return nil, fmt.Errorf("can not auth with azure via default credentials: %w", err)
 	}
-	return azclient, nil
+	return source.NewAzure(azclient), nil
+}
+
+func ensureQueue(ctx context.Context) (*monitor.QueueMonitor, error) {
+	eventsQueue = viper.Get("EVENTS_QUEUE").(string)
+	if eventsQueue == "" {
+		return nil, nil
+	}
+
+	if strings.TrimSpace(connectionString) != "" {
+		qclient, err := authQueueConnectionString(ctx, connectionString, eventsQueue)
+		if err != nil {
+			return nil, fmt.Errorf("can not auth with azure queue via connection-string: %w", err)
+		}
+		return monitor.NewQueueMonitor(qclient), nil
+	}
+
+	queueURL = viper.Get("QUEUE_URL").(string)
+	if queueURL == "" {
+		queueURL = strings.Replace(storageURL, ".blob.", ".queue.", 1)
+	}
+
+	qclient, err := authQueueDefault(ctx, queueURL, eventsQueue)
+	if err != nil {
+		return nil, fmt.Errorf("can not auth with azure queue via default credentials: %w", err)
+	}
+	return monitor.NewQueueMonitor(qclient), nil
+}
+
+// ensureSink creates the sinks the rows are written to, checking the axiom token
+// can ingest if axiom is one of them.
+func ensureSink(ctx context.Context, src source.Source, axmclient *axm.Client, sam *monitor.StorageAccountMonitor, cfg *config.Config, filter *monitor.TableFilter) (sink.Sink, error) {
+	var sinks []sink.Sink
+	for _, name := range sinkList() {
+		switch name {
+		case "axiom":
+			if err := checkAxiomAccess(ctx, src, axmclient, sam, cfg, filter); err != nil {
+				return nil, err
+			}
+			sinks = append(sinks, sink.NewAxiom(axmclient))
+
+		case "file":
+			sinkDir = viper.GetString("SINK_DIR")
+			logger.Info("writing rows to directory", "dir", sinkDir)
+			sinks = append(sinks, sink.NewFile(sinkDir))
+
+		case "stdout":
+			sinks = append(sinks, sink.NewStdout())
+
+		default:
+			return nil, fmt.Errorf("unknown sink %q, must be axiom, file or stdout", name)
+		}
+	}
+
+	if len(sinks) == 1 {
+		return sinks[0], nil
+	}
+	return sink.NewTee(sinks...), nil
+}
+
+// sinkList returns the names of the sinks in --sink.
+func sinkList() []string {
+	names := strings.Split(sinkNames, ",")
+	for i, name := range names {
+		names[i] = strings.TrimSpace(name)
+	}
+	return names
+}
+
+// ensureAxiom creates the axiom client for the token, which may be an API token or
+// a personal token.
+func ensureAxiom() (*axm.Client, error) {
+	if axiomToken == "" {
+		return nil, fmt.Errorf("axiom token is required")
+	}
+
+	tokenType, err := axm.DetectTokenType(axiomToken)
+	if err != nil {
+		return nil, err
+	}
+
+	tokenConfig := axiom.SetAPITokenConfig(axiomToken)
+	if tokenType == axm.TokenPersonal {
+		axiomPersonalOrg = viper.GetString("AXIOM_ORG")
+		if axiomPersonalOrg == "" {
+			return nil, fmt.Errorf("axiom org is required when using a personal token")
+		}
+		tokenConfig = axiom.SetPersonalTokenConfig(axiomToken, axiomPersonalOrg)
+	}
+	logger.Info("using axiom token", "type", tokenType)
+
+	axiclient, err := axiom.NewClient(
+		tokenConfig,
+		axiom.SetURL(viper.GetString("AXIOM_URL")),
+		axiom.SetClient(&http.Client{Transport: axm.Transport(axiom.DefaultHTTPTransport())}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("can not create axiom client: %w", err)
+	}
+
+	ingestRetries, ingestBackoff = viper.GetInt("INGEST_RETRIES"), viper.GetDuration("INGEST_BACKOFF")
+
+	return &axm.Client{
+		Client:        axiclient,
+		DatasetPrefix: axiomDatasetPrefix,
+		Retry: axm.RetryPolicy{
+			MaxAttempts:     ingestRetries,
+			InitialInterval: ingestBackoff,
+			MaxInterval:     axm.DefaultRetryPolicy.MaxInterval,
+		},
+		APIToken:         tokenType == axm.TokenAPI,
+		NoCreateDatasets: viper.GetBool("NO_CREATE_DATASETS"),
+	}, nil
+}
+
+// ensureAudit checks the axiom token can ingest into the audit dataset, which
+// isn't prefixed like the datasets of the tables, and creates the checkpoint
+// container the end of the audit chain is kept in.
+func ensureAudit(ctx context.Context, src source.Source, axmclient *axm.Client) (poll.Option, error) {
+	dataset := axm.NewDataset(auditDataset)
+	dataset.TimestampField = "_time"
+	dataset.Unprefixed = true
+	if err := axmclient.CheckAccess(ctx, []*axm.Dataset{dataset}); err != nil {
+		return nil, err
+	}
+
+	checkpointContainer = viper.GetString("CHECKPOINT_CONTAINER")
+	checkpoints := monitor.NewCheckpointStore(checkpointContainer)
+	if err := checkpoints.Ensure(ctx, src); err != nil {
+		return nil, err
+	}
+
+	return poll.WithAudit(axmclient, dataset, checkpoints), nil
+}
+
+// checkAxiomAccess makes sure the token can ingest into the dataset of every
+// enabled table that has been exported to the storage account so far.
+func checkAxiomAccess(ctx context.Context, src source.Source, axmclient *axm.Client, sam *monitor.StorageAccountMonitor, cfg *config.Config, filter *monitor.TableFilter) error {
+	containers, err := sam.ListContainers(ctx, src)
+	if err != nil {
+		return fmt.Errorf("can not list containers: %w", err)
+	}
+
+	datasets := make([]*axm.Dataset, 0, len(containers))
+	for _, container := range containers {
+		table := cfg.Table(container.TableName())
+		if !filter.Match(container.TableName()) || !table.IsEnabled() {
+			continue
+		}
+
+		name := table.Dataset
+		if name == "" {
+			name = container.TableName()
+		}
+		datasets = append(datasets, axm.NewDataset(name))
+	}
+
+	return axmclient.CheckAccess(ctx, datasets)
 }
 
 func export(cmd *cobra.Command, args []string) {
 	ctx, cancel := context.WithCancel(cmd.Context())
 	defer cancel()
 
-	azclient, err := ensureValid(ctx)
+	// a dry run doesn't delete anything, so it would go over the same blobs forever
+	dryRun = viper.GetBool("DRY_RUN")
+	once = viper.GetBool("ONCE") || dryRun
+
+	// jobs need to know when a run failed, including when it couldn't start
+	succeeded := false
+	defer func() {
+		if once && !succeeded {
+			os.Exit(1)
+		}
+	}()
+
+	// the stdout sink writes the rows to stdout, so the logs can't go there too
+	logOut := cmd.OutOrStdout()
+	for _, name := range strings.Split(viper.GetString("SINK"), ",") {
+		if strings.TrimSpace(name) == "stdout" {
+			logOut = cmd.ErrOrStderr()
+		}
+	}
+	logLevel, logFormat = viper.GetString("LOG_LEVEL"), viper.GetString("LOG_FORMAT")
+	if err := logging.Setup(logOut, logLevel, logFormat); err != nil {
+		fmt.Fprintf(cmd.ErrOrStderr(), "error validating: %s", err)
+		return
+	}
+
+	if otlpEndpoint = viper.GetString("OTLP_ENDPOINT"); otlpEndpoint != "" {
+		shutdown, err := tracing.Setup(ctx, otlpEndpoint)
+		if err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "error validating: %s", err)
+			return
+		}
+		defer func() {
+			// the run's context may be canceled by now, the last spans are sent anyway
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := shutdown(ctx); err != nil {
+				logger.Warn("can not flush traces", "error", err)
+			}
+		}()
+		logger.Info("sending traces", "endpoint", otlpEndpoint)
+
+		azureTransport = &http.Client{Transport: tracing.Transport(http.DefaultTransport)}
+	}
+
+	cfg, err := loadConfig()
 	if err != nil {
 		fmt.Fprintf(cmd.ErrOrStderr(), "error validating: %s", err)
 		return
 	}
 
-	axiclient, err := axiom.NewClient(
-		//axiom.SetAPITokenConfig(axiomAPIKey),
-		axiom.SetPersonalTokenConfig(axiomPersonalAPIKey, axiomPersonalOrg),
-		axiom.SetURL(axiomURL),
-	)
+	src, err := ensureValid(ctx)
 	if err != nil {
-		fmt.Fprintf(cmd.ErrOrStderr(), "can not create axiom client: %s\n", err)
+		fmt.Fprintf(cmd.ErrOrStderr(), "error validating: %s", err)
+		return
+	}
+
+	probes := &probes{
+		window: viper.GetDuration("LIVENESS_WINDOW"),
+	}
+	httpAddr = viper.GetString("HTTP_ADDR")
+	if httpAddr != "" {
+		if err := serve(ctx, httpAddr, probes); err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "error validating: %s", err)
+			return
+		}
 	}
 
+	axiomDatasetPrefix = viper.GetString("AXIOM_DATASET_PREFIX")
 	if axiomDatasetPrefix != "" {
-		fmt.Fprintf(cmd.OutOrStdout(), "using axiom dataset prefix: %s\n", axiomDatasetPrefix)
+		logger.Info("using axiom dataset prefix", "prefix", axiomDatasetPrefix)
 	}
 
-	poller := poll.NewPoller(workerPoolSize)
-	axmclient := &axm.Client{
-		Client:        axiclient,
-		DatasetPrefix: axiomDatasetPrefix,
+	queue, err := ensureQueue(ctx)
+	if err != nil {
+		fmt.Fprintf(cmd.ErrOrStderr(), "error validating: %s", err)
+		return
 	}
 
-	if err := poller.Start(ctx, azclient, axmclient, monitor.NewStorageAccountMonitor(storageURL)); err != nil {
-		fmt.Fprintf(cmd.ErrOrStderr(), "can not start poller: %s\n", err)
+	settleGrace, settleQuiet = viper.GetDuration("SETTLE_GRACE"), viper.GetDuration("SETTLE_QUIET")
+	pollOptions := []poll.Option{
+		poll.WithSettlePolicy(monitor.SettlePolicy{
+			Grace: settleGrace,
+			Quiet: settleQuiet,
+		}),
+		poll.WithStaleAfter(viper.GetDuration("STALE_AFTER")),
+	}
+	if cfg != nil {
+		logger.Info("using config", "path", configFile)
+		pollOptions = append(pollOptions, poll.WithTables(cfg.Tables))
+	}
+
+	includeTables, excludeTables = viper.GetString("INCLUDE_TABLES"), viper.GetString("EXCLUDE_TABLES")
+	filter, err := monitor.NewTableFilter(strings.Split(includeTables, ","), strings.Split(excludeTables, ","))
+	if err != nil {
+		fmt.Fprintf(cmd.ErrOrStderr(), "error validating: %s", err)
+		return
+	}
+	if includeTables != "" || excludeTables != "" {
+		logger.Info("exporting matching tables", "include", includeTables, "exclude", excludeTables)
+		pollOptions = append(pollOptions, poll.WithTableFilter(filter))
+	}
+	if queue != nil {
+		logger.Info("using blob events", "queue", eventsQueue)
+		reconcileInterval = viper.GetDuration("RECONCILE_INTERVAL")
+		pollOptions = append(pollOptions, poll.WithEvents(queue, reconcileInterval))
+	}
+
+	policy, err := poll.ParseFailurePolicy(viper.GetString("FAILURE_POLICY"))
+	if err != nil {
+		fmt.Fprintf(cmd.ErrOrStderr(), "error validating: %s", err)
+		return
+	}
+
+	pollOptions = append(pollOptions, poll.WithFailurePolicy(policy))
+
+	maxAttempts = viper.GetInt("MAX_ATTEMPTS")
+	if !dryRun && (maxAttempts > 0 || policy == poll.FailureDeadLetter) {
+		deadLetterContainer = viper.GetString("DEADLETTER_CONTAINER")
+		deadLetters := monitor.NewDeadLetterStore(deadLetterContainer)
+		if err := deadLetters.Ensure(ctx, src); err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "error validating: %s", err)
+			return
+		}
+		logger.Info("using dead-letter container", "container", deadLetterContainer)
+		pollOptions = append(pollOptions, poll.WithDeadLetters(deadLetters, maxAttempts))
+	}
+
+	retain, tail := viper.GetBool("RETAIN_BLOBS"), viper.GetBool("TAIL_BLOBS")
+	if retain || tail {
+		checkpointContainer = viper.GetString("CHECKPOINT_CONTAINER")
+		checkpoints := monitor.NewCheckpointStore(checkpointContainer)
+		// a dry run only reads the checkpoints
+		if !dryRun {
+			if err := checkpoints.Ensure(ctx, src); err != nil {
+				fmt.Fprintf(cmd.ErrOrStderr(), "error validating: %s", err)
+				return
+			}
+		}
+
+		if retain {
+			logger.Info("retaining blobs", "checkpoint_container", checkpointContainer)
+			pollOptions = append(pollOptions, poll.WithCheckpoints(checkpoints))
+		}
+		if tail {
+			logger.Info("tailing blobs", "checkpoint_container", checkpointContainer)
+			pollOptions = append(pollOptions, poll.WithTailing(checkpoints))
+		}
+	}
+
+	if dryRun {
+		pollOptions = append(pollOptions, poll.WithDryRun())
+	}
+
+	// a dry run ships nothing, so there is nothing to audit or ingest
+	sinkNames, auditDataset = viper.GetString("SINK"), viper.GetString("AUDIT_DATASET")
+	var axmclient *axm.Client
+	if !dryRun && (auditDataset != "" || slices.Contains(sinkList(), "axiom")) {
+		// the sink and the audit trail share the client, and so its rate limits
+		axmclient, err = ensureAxiom()
+		if err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "error validating: %s", err)
+			return
+		}
+	}
+
+	if auditDataset != "" && !dryRun {
+		audit, err := ensureAudit(ctx, src, axmclient)
+		if err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "error validating: %s", err)
+			return
+		}
+		logger.Info("writing audit events", "dataset", auditDataset, "checkpoint_container", checkpointContainer)
+		pollOptions = append(pollOptions, audit)
+	}
+
+	poller := poll.NewPoller(workerPoolSize, pollOptions...)
+	sam := monitor.NewStorageAccountMonitor(storageURL)
+
+	var out sink.Sink
+	if dryRun {
+		logger.Info("dry run, nothing is sent to axiom or deleted")
+		out = sink.NewDryRun(axiomDatasetPrefix)
+	} else {
+		out, err = ensureSink(ctx, src, axmclient, sam, cfg, filter)
+		if err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "error validating: %s", err)
+			return
+		}
 	}
 
 	sigTrap := make(chan os.Signal, 1)
 	signal.Notify(sigTrap, os.Interrupt, syscall.SIGTERM)
 
+	if once {
+		go func() {
+			select {
+			case <-sigTrap:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+
+		probes.poller.Store(poller)
+		err := poller.Drain(ctx, src, out, sam)
+		summary := poller.Summary()
+		printSummary(cmd.OutOrStdout(), summary)
+		if dryRun, ok := out.(*sink.DryRun); ok {
+			printDryRun(cmd.OutOrStdout(), dryRun)
+		}
+		if err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "can not drain: %s\n", err)
+			return
+		}
+		if summary.Failed() || ctx.Err() != nil {
+			fmt.Fprintln(cmd.ErrOrStderr(), "export failed")
+			return
+		}
+
+		succeeded = true
+		cmd.Println("finished exporting")
+		return
+	}
+
+	if err := poller.Start(ctx, src, out, sam); err != nil {
+		fmt.Fprintf(cmd.ErrOrStderr(), "can not start poller: %s\n", err)
+	}
+	probes.poller.Store(poller)
+
 	select {
 	case <-sigTrap:
 		cancel()
@@ -159,5 +746,25 @@ func export(cmd *cobra.Command, args []string) {
 		fmt.Fprintf(cmd.ErrOrStderr(), "can not stop poller: %s\n", err)
 	}
 
+	succeeded = true
 	cmd.Println("finished exporting")
 }
+
+func printDryRun(w io.Writer, dryRun *sink.DryRun) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "DATASET\tROWS\tBYTES\tINVALID\tMISSING TIMESTAMP\tBAD TIMESTAMP\tOLDEST\tNEWEST")
+	for _, ds := range dryRun.Report() {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\t%s\t%s\n", ds.Dataset, ds.Rows, ds.Bytes, ds.Invalid, ds.MissingTimestamp, ds.BadTimestamp,
+			ds.Oldest.Format(time.RFC3339), ds.Newest.Format(time.RFC3339))
+	}
+	tw.Flush()
+}
+
+func printSummary(w io.Writer, summary *poll.Summary) {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tBLOBS\tBYTES\tINGESTED\tFAILED\tERRORS")
+	for _, ts := range summary.Tables() {
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%d\t%d\n", ts.Table, ts.Blobs, ts.Bytes, ts.Ingested, ts.Failed, ts.Errors)
+	}
+	tw.Flush()
+}
@@ -0,0 +1,87 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/axiomhq/sentinelexport/pkg/metrics"
+	"github.com/axiomhq/sentinelexport/pkg/poll"
+)
+
+// probes answers the liveness and readiness checks of the orchestrator.
+type probes struct {
+	// window is how long the poller may go without making progress, see
+	// poll.Poll.Live
+	window time.Duration
+	// poller is set once the credentials are validated and it started
+	poller atomic.Pointer[poll.Poll]
+}
+
+func (p *probes) healthz(w http.ResponseWriter, r *http.Request) {
+	poller := p.poller.Load()
+	if poller == nil {
+		// still starting up, which is alive
+		fmt.Fprintln(w, "ok")
+		return
+	}
+
+	if err := poller.Live(p.window); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+func (p *probes) readyz(w http.ResponseWriter, r *http.Request) {
+	poller := p.poller.Load()
+	if poller == nil {
+		http.Error(w, "validating credentials", http.StatusServiceUnavailable)
+		return
+	}
+
+	if err := poller.Ready(); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	fmt.Fprintln(w, "ok")
+}
+
+// serve serves the metrics and probes on addr until ctx is done.
+func serve(ctx context.Context, addr string, probes *probes) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", metrics.Handler())
+	mux.HandleFunc("/healthz", probes.healthz)
+	mux.HandleFunc("/readyz", probes.readyz)
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("can not listen on %s: %w", addr, err)
+	}
+
+	srv := &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		if err := srv.Serve(ln); !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("can not serve", "addr", addr, "error", err)
+		}
+	}()
+
+	go func() {
+		<-ctx.Done()
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	logger.Info("serving metrics and health checks", "addr", ln.Addr().String())
+	return nil
+}
@@ -0,0 +1,123 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/axiomhq/sentinelexport/pkg/monitor"
+	"github.com/axiomhq/sentinelexport/pkg/poll"
+	"github.com/axiomhq/sentinelexport/pkg/sink"
+	"github.com/axiomhq/sentinelexport/pkg/source"
+)
+
+// gatedSource lists its containers once gate is closed, failing with err if set.
+type gatedSource struct {
+	source.Source
+	gate chan struct{}
+	err  error
+}
+
+func (s *gatedSource) ListContainers(ctx context.Context, prefix string) ([]string, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-s.gate:
+	}
+
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.Source.ListContainers(ctx, prefix)
+}
+
+func startPoller(t *testing.T, src source.Source) *poll.Poll {
+	t.Helper()
+
+	poller := poll.NewPoller(1)
+	if err := poller.Start(context.Background(), src, sink.NewStdout(), monitor.NewStorageAccountMonitor("")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = poller.Stop() })
+	return poller
+}
+
+func probe(t *testing.T, handler http.HandlerFunc) (int, string) {
+	t.Helper()
+
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	return w.Code, strings.TrimSpace(w.Body.String())
+}
+
+func eventually(t *testing.T, what string, fn func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if fn() {
+			return
+		}
+	}
+	t.Fatalf("timed out waiting for %s", what)
+}
+
+func TestProbes(t *testing.T) {
+	p := &probes{window: 200 * time.Millisecond}
+
+	// validating the credentials
+	if code, _ := probe(t, p.healthz); code != http.StatusOK {
+		t.Errorf("starting up isn't live: %d", code)
+	}
+	if code, _ := probe(t, p.readyz); code != http.StatusServiceUnavailable {
+		t.Errorf("starting up is ready: %d", code)
+	}
+
+	src := &gatedSource{Source: source.NewDir(t.TempDir()), gate: make(chan struct{})}
+	p.poller.Store(startPoller(t, src))
+
+	if code, body := probe(t, p.readyz); code != http.StatusServiceUnavailable || body != "containers weren't listed yet" {
+		t.Errorf("ready before the first cycle: %d %s", code, body)
+	}
+
+	// readiness flips once the first cycle listed the containers
+	close(src.gate)
+	eventually(t, "the poller to be ready", func() bool {
+		code, _ := probe(t, p.readyz)
+		return code == http.StatusOK
+	})
+	if code, body := probe(t, p.healthz); code != http.StatusOK {
+		t.Errorf("not live after listing: %d %s", code, body)
+	}
+
+	// the next listing is a poll interval away, far longer than the window, so
+	// to the probe the loop has stalled
+	eventually(t, "the liveness check to fail", func() bool {
+		code, body := probe(t, p.healthz)
+		return code == http.StatusServiceUnavailable && strings.HasPrefix(body, "no listing of the containers succeeded for")
+	})
+	if code, _ := probe(t, p.readyz); code != http.StatusOK {
+		t.Errorf("a stalled poller stopped being ready: %d", code)
+	}
+}
+
+func TestProbesListingFails(t *testing.T) {
+	p := &probes{window: 200 * time.Millisecond}
+
+	gate := make(chan struct{})
+	close(gate)
+	src := &gatedSource{Source: source.NewDir(t.TempDir()), gate: gate, err: errors.New("storage account is down")}
+	p.poller.Store(startPoller(t, src))
+
+	eventually(t, "the failed listing", func() bool {
+		code, body := probe(t, p.readyz)
+		return code == http.StatusServiceUnavailable && strings.Contains(body, "storage account is down")
+	})
+	eventually(t, "the liveness check to fail", func() bool {
+		code, body := probe(t, p.healthz)
+		return code == http.StatusServiceUnavailable && strings.HasPrefix(body, "no listing of the containers succeeded since starting")
+	})
+}
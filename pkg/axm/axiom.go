@@ -5,30 +5,71 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
-	"os"
-	"slices"
+	"net/http"
+	"net/url"
+	"path"
+	"time"
 
 	"github.com/axiomhq/axiom-go/axiom"
 	"github.com/axiomhq/axiom-go/axiom/ingest"
+	"github.com/axiomhq/sentinelexport/pkg/logging"
+	"github.com/axiomhq/sentinelexport/pkg/tracing"
 )
 
-var logger = log.New(os.Stdout, "axiom: ", log.LstdFlags)
+var (
+	logger = logging.New("axiom")
+	tracer = tracing.New("axm")
+)
+
+// DefaultTimestampField is the field sentinel tables keep their timestamp in.
+const DefaultTimestampField = "TimeGenerated"
 
 type Client struct {
 	*axiom.Client
 
 	DatasetPrefix string
+	Retry         RetryPolicy
+
+	// APIToken is set when the client uses an API token, which can't list
+	// datasets, so they are created without looking whether they exist first.
+	APIToken bool
+	// NoCreateDatasets fails shipping to datasets that don't exist, rather than
+	// creating them, for tokens that aren't allowed to create datasets.
+	NoCreateDatasets bool
+	// DatasetRefresh is how often the datasets are listed again, defaults to
+	// DefaultDatasetRefresh.
+	DatasetRefresh time.Duration
+
+	breaker  breaker
+	datasets datasetRegistry
 }
 
 type Dataset struct {
 	name string
+
+	// TimestampField is the field axiom takes _time from, defaults to
+	// DefaultTimestampField.
+	TimestampField string
+	// TimestampFormat is the Go time layout of the timestamp field, axiom
+	// detects it when empty.
+	TimestampFormat string
+	// Unprefixed datasets, like the audit dataset, aren't given the client's
+	// DatasetPrefix.
+	Unprefixed bool
 }
 
 func (d *Dataset) Name() string {
 	return d.name
 }
 
+// NameIn returns the name of the dataset in axiom, with the client's prefix.
+func (d *Dataset) NameIn(client *Client) string {
+	if d.Unprefixed {
+		return d.name
+	}
+	return client.DatasetPrefix + d.name
+}
+
 func NewDataset(name string) *Dataset {
 	return &Dataset{
 		name: name,
@@ -37,42 +78,134 @@ func NewDataset(name string) *Dataset {
 
 func (d *Dataset) Ensure(ctx context.Context, client *Client) error {
 	// ensure the dataset exists in axiom
-	name := client.DatasetPrefix + d.name
+	name := d.NameIn(client)
 
-	dses, err := client.Datasets.List(ctx)
+	if client.APIToken {
+		return d.ensureWithAPIToken(ctx, client, name)
+	}
+
+	refresh := client.DatasetRefresh
+	if refresh <= 0 {
+		refresh = DefaultDatasetRefresh
+	}
+
+	exists, err := client.datasets.exists(ctx, client, name, refresh)
 	if err != nil {
-		return fmt.Errorf("can not list datasets: %w", err)
+		return err
+	}
+	if exists {
+		return nil
+	}
+
+	if client.NoCreateDatasets {
+		return fmt.Errorf("dataset %q does not exist and creating datasets is disabled", name)
+	}
+
+	if err := create(ctx, client, name); err != nil {
+		return err
+	}
+
+	client.datasets.add(name)
+	return nil
+}
+
+// ensureWithAPIToken creates the dataset, as an API token can't list the
+// datasets to see whether it exists. A token that isn't allowed to create
+// datasets can still ingest into the ones it was given, so then the dataset is
+// assumed to exist and a missing one fails the ingest instead.
+func (d *Dataset) ensureWithAPIToken(ctx context.Context, client *Client, name string) error {
+	if client.NoCreateDatasets || client.datasets.known(name) {
+		return nil
+	}
+
+	err := create(ctx, client, name)
+	if err != nil && !errors.Is(err, axiom.ErrUnauthenticated) && !errors.Is(err, axiom.ErrUnauthorized) {
+		return err
 	}
 
-	exists := slices.ContainsFunc(dses, func(ds *axiom.Dataset) bool {
-		return ds.Name == name
-	})
+	client.datasets.mark(name)
+	return nil
+}
+
+func create(ctx context.Context, client *Client, name string) error {
+	req := axiom.DatasetCreateRequest{
+		Name:        name,
+		Description: "imported from Sentinel",
+	}
 
-	if !exists {
-		_, err := client.Datasets.Create(ctx, axiom.DatasetCreateRequest{
-			Name:        name,
-			Description: "imported from Sentinel",
-		})
-		if err != nil && !errors.Is(err, axiom.ErrExists) {
-			return fmt.Errorf("can not create dataset: %w", err)
-		}
+	var err error
+	if client.APIToken {
+		err = createWithAPIToken(ctx, client, req)
+	} else {
+		_, err = client.Datasets.Create(ctx, req)
+	}
+	// another worker may have created it in the meantime
+	if err != nil && !errors.Is(err, axiom.ErrExists) {
+		return fmt.Errorf("can not create dataset: %w", err)
 	}
 
 	return nil
 }
 
+// createWithAPIToken creates a dataset with an API token. The axiom client only
+// sends ingests and queries with one, though API tokens can be allowed to create
+// datasets, so the request starts out as an ingest into the dataset to get the
+// url and headers of the client.
+func createWithAPIToken(ctx context.Context, client *Client, create axiom.DatasetCreateRequest) error {
+	req, err := client.NewRequest(ctx, http.MethodPost, "/v1/datasets/"+url.PathEscape(create.Name)+"/ingest", create)
+	if err != nil {
+		return err
+	}
+	req.URL.Path = path.Dir(path.Dir(req.URL.Path))
+	req.URL.RawPath = ""
+
+	_, err = client.Do(req, nil)
+	return err
+}
+
+// missing explains an ingest that failed because the dataset doesn't exist,
+// and forgets the dataset so the next blob ensures it again.
+func (d *Dataset) missing(client *Client, err error) error {
+	if !errors.Is(err, axiom.ErrNotFound) {
+		return err
+	}
+
+	name := d.NameIn(client)
+	client.datasets.forget(name)
+
+	switch {
+	case client.NoCreateDatasets:
+		return fmt.Errorf("dataset %q does not exist and creating datasets is disabled: %w", name, err)
+	case client.APIToken:
+		return fmt.Errorf("dataset %q does not exist and the API token isn't allowed to create it, so it has to be created up front: %w", name, err)
+	}
+	return fmt.Errorf("dataset %q does not exist anymore, it is created again for the next blob: %w", name, err)
+}
+
 func (d *Dataset) Stream(ctx context.Context, client *Client, r io.Reader) (*ingest.Status, error) {
 	r, err := axiom.GzipEncoder()(r)
 	if err != nil {
 		return nil, err
 	}
 
-	name := client.DatasetPrefix + d.name
+	name := d.NameIn(client)
 
-	logger.Printf("streaming to axiom dataset => %q\n", name)
+	logger.Debug("streaming to axiom", "dataset", name)
 
-	return client.Ingest(ctx, name, r, axiom.NDJSON, axiom.Gzip,
-		// TODO: non sentinel uses other fields, but sentinel seems to only use these fields
-		ingest.SetTimestampField("TimeGenerated"), //az uses TimeGenerated, axiom uses _time
-	)
+	options := []ingest.Option{
+		ingest.SetTimestampField(d.TimestampFieldName()), //az uses TimeGenerated, axiom uses _time
+	}
+	if d.TimestampFormat != "" {
+		options = append(options, ingest.SetTimestampFormat(d.TimestampFormat))
+	}
+
+	return client.Ingest(ctx, name, r, axiom.NDJSON, axiom.Gzip, options...)
+}
+
+// TimestampFieldName returns the field axiom takes _time from.
+func (d *Dataset) TimestampFieldName() string {
+	if d.TimestampField == "" {
+		return DefaultTimestampField
+	}
+	return d.TimestampField
 }
@@ -0,0 +1,102 @@
+package axm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DefaultDatasetRefresh is how often the datasets are listed again, to notice
+// datasets that were created or deleted by someone else.
+const DefaultDatasetRefresh = 10 * time.Minute
+
+// datasetRegistry caches which datasets exist, so shipping a blob doesn't have
+// to list every dataset. The zero value is empty and loads on first use.
+type datasetRegistry struct {
+	mu       sync.Mutex
+	names    map[string]struct{}
+	loadedAt time.Time
+}
+
+// exists reports whether the dataset exists, listing the datasets again if the
+// cache is older than refresh.
+func (r *datasetRegistry) exists(ctx context.Context, client *Client, name string, refresh time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names == nil || time.Since(r.loadedAt) >= refresh {
+		if err := r.load(ctx, client); err != nil {
+			return false, err
+		}
+	}
+
+	_, ok := r.names[name]
+	return ok, nil
+}
+
+// load must be called with r.mu held.
+func (r *datasetRegistry) load(ctx context.Context, client *Client) error {
+	dses, err := client.Datasets.List(ctx)
+	if err != nil {
+		return fmt.Errorf("can not list datasets: %w", err)
+	}
+
+	names := make(map[string]struct{}, len(dses))
+	for _, ds := range dses {
+		names[ds.Name] = struct{}{}
+	}
+
+	r.names = names
+	r.loadedAt = time.Now()
+	return nil
+}
+
+func (r *datasetRegistry) add(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names == nil {
+		// not loaded yet, the first use lists the datasets anyway
+		return
+	}
+	r.names[name] = struct{}{}
+}
+
+// known reports whether the dataset was ensured, for API tokens, which can't
+// list the datasets.
+func (r *datasetRegistry) known(name string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.names[name]
+	return ok
+}
+
+// mark records that the dataset was ensured with an API token.
+func (r *datasetRegistry) mark(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.names == nil {
+		r.names = map[string]struct{}{}
+	}
+	r.names[name] = struct{}{}
+}
+
+// forget drops a dataset that turned out not to exist.
+func (r *datasetRegistry) forget(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.names, name)
+}
+
+// LoadDatasets lists the datasets that exist, so it is done once at startup
+// rather than by the first blob of every table.
+func (c *Client) LoadDatasets(ctx context.Context) error {
+	c.datasets.mu.Lock()
+	defer c.datasets.mu.Unlock()
+
+	return c.datasets.load(ctx, c)
+}
@@ -0,0 +1,228 @@
+package axm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/axiomhq/axiom-go/axiom"
+	"github.com/axiomhq/axiom-go/axiom/ingest"
+	"github.com/axiomhq/sentinelexport/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RetryPolicy is how ingest requests that failed with a 429, a 5xx or a network
+// error are retried.
+type RetryPolicy struct {
+	// MaxAttempts is the number of times an ingest is tried before giving up.
+	MaxAttempts int
+	// InitialInterval is the backoff after the first failure, it doubles after
+	// every failure up to MaxInterval.
+	InitialInterval time.Duration
+	MaxInterval     time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:     5,
+	InitialInterval: time.Second,
+	MaxInterval:     time.Minute,
+}
+
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	wait := p.InitialInterval << (attempt - 1)
+	if wait <= 0 || wait > p.MaxInterval {
+		wait = p.MaxInterval
+	}
+
+	// jitter, so workers that failed together don't retry together
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// breaker pauses every ingest while axiom is rate limiting us, the zero value
+// is closed.
+type breaker struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+func (b *breaker) trip(until time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if until.After(b.until) {
+		b.until = until
+		logger.Warn("rate limited, pausing ingest", "until", until)
+	}
+}
+
+func (b *breaker) wait(ctx context.Context) error {
+	b.mu.Lock()
+	wait := time.Until(b.until)
+	b.mu.Unlock()
+
+	if wait <= 0 {
+		return nil
+	}
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-time.After(wait):
+		return nil
+	}
+}
+
+// StreamWithRetry streams to the dataset, retrying with backoff as set by the
+// client's retry policy. The reader is used up by every attempt, so open is
+// called again for each one.
+func (d *Dataset) StreamWithRetry(ctx context.Context, client *Client, open func() (io.ReadCloser, error)) (*ingest.Status, error) {
+	policy := client.Retry
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := client.breaker.wait(ctx); err != nil {
+			return nil, err
+		}
+
+		// the azure sdk already retries downloads
+		r, err := open()
+		if err != nil {
+			return nil, err
+		}
+
+		var retryAfter time.Duration
+		attemptCtx, span := tracer.Start(context.WithValue(ctx, retryAfterKey{}, &retryAfter), "axiom.ingest", trace.WithAttributes(
+			attribute.String("dataset", d.NameIn(client)),
+			attribute.Int("attempt", attempt),
+		))
+		status, err := d.Stream(attemptCtx, client, r)
+		r.Close()
+		tracing.End(span, err)
+		if err == nil {
+			return status, nil
+		}
+
+		if !retryable(err) {
+			return nil, d.missing(client, err)
+		}
+		if attempt >= policy.MaxAttempts {
+			return nil, fmt.Errorf("giving up after %d attempts: %w", attempt, err)
+		}
+
+		wait := max(policy.backoff(attempt), retryAfter)
+
+		var limitErr axiom.LimitError
+		if errors.As(err, &limitErr) {
+			wait = max(wait, time.Until(limitErr.Limit.Reset))
+		}
+		if rateLimited(err) {
+			client.breaker.trip(time.Now().Add(wait))
+		}
+
+		logger.Warn("ingest failed, retrying", "dataset", d.NameIn(client), "attempt", attempt, "max_attempts", policy.MaxAttempts, "wait", wait.Truncate(time.Millisecond), "error", err)
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(wait):
+		}
+	}
+}
+
+func retryable(err error) bool {
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	var limitErr axiom.LimitError
+	if errors.As(err, &limitErr) {
+		return true
+	}
+
+	var httpErr axiom.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Status == http.StatusTooManyRequests || httpErr.Status >= http.StatusInternalServerError
+	}
+
+	// anything else didn't get an answer from axiom, e.g. a network error
+	return true
+}
+
+// rateLimited reports whether axiom asked us to slow down, which pauses every
+// ingest rather than just the one that failed.
+func rateLimited(err error) bool {
+	var limitErr axiom.LimitError
+	if errors.As(err, &limitErr) {
+		return true
+	}
+
+	var httpErr axiom.HTTPError
+	if errors.As(err, &httpErr) {
+		return httpErr.Status == http.StatusTooManyRequests || httpErr.Status == http.StatusServiceUnavailable
+	}
+	return false
+}
+
+// retryAfterKey holds where Transport stores the Retry-After of the response to
+// an ingest, as axiom's errors don't carry the headers.
+type retryAfterKey struct{}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+// Transport passes the Retry-After header of rate limited and unavailable
+// responses on to StreamWithRetry, so it waits at least that long before trying
+// again.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return &transport{base: base}
+}
+
+func (t *transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	retryAfter, ok := req.Context().Value(retryAfterKey{}).(*time.Duration)
+	if ok && (resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable) {
+		*retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+	}
+	return resp, nil
+}
+
+// parseRetryAfter parses a Retry-After header, which is either a number of
+// seconds or a date, into how long to wait.
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
+
+// Rejected reports whether axiom refused the ingest because of what was sent,
+// e.g. a body it can't parse, so sending the same rows again won't help.
+func Rejected(err error) bool {
+	var httpErr axiom.HTTPError
+	if !errors.As(err, &httpErr) {
+		return false
+	}
+
+	switch httpErr.Status {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,78 @@
+package axm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/axiomhq/axiom-go/axiom"
+)
+
+// TokenType is the kind of axiom token, told apart by its prefix.
+type TokenType string
+
+const (
+	// TokenAPI can only ingest into and query the datasets it was given access
+	// to, and create datasets if it was allowed to. It can't list datasets.
+	TokenAPI TokenType = "api"
+	// TokenPersonal has every permission of its user and needs an org id.
+	TokenPersonal TokenType = "personal"
+)
+
+// DetectTokenType tells an API token from a personal token.
+func DetectTokenType(token string) (TokenType, error) {
+	switch {
+	case strings.HasPrefix(token, "xaat-"):
+		return TokenAPI, nil
+	case strings.HasPrefix(token, "xapt-"):
+		return TokenPersonal, nil
+	}
+
+	return "", errors.New("unknown axiom token type, expected an API token (xaat-...) or a personal token (xapt-...)")
+}
+
+// CheckAccess makes sure the token can ingest into the datasets, creating the
+// ones that don't exist yet unless NoCreateDatasets is set. A token missing a
+// permission fails here rather than on every blob.
+func (c *Client) CheckAccess(ctx context.Context, datasets []*Dataset) error {
+	if !c.APIToken {
+		if err := c.ValidateCredentials(ctx); err != nil {
+			return fmt.Errorf("can not authenticate with axiom: %w", err)
+		}
+		if err := c.LoadDatasets(ctx); err != nil {
+			return err
+		}
+	}
+
+	for _, ds := range datasets {
+		if err := ds.Ensure(ctx, c); err != nil {
+			return err
+		}
+		if err := ds.checkIngest(ctx, c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkIngest ingests nothing into the dataset, which fails the same way a real
+// ingest would without the permission to.
+func (d *Dataset) checkIngest(ctx context.Context, client *Client) error {
+	name := d.NameIn(client)
+
+	_, err := client.Ingest(ctx, name, strings.NewReader(""), axiom.NDJSON, axiom.Identity)
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, axiom.ErrUnauthenticated):
+		return fmt.Errorf("axiom token is not valid: %w", err)
+	case errors.Is(err, axiom.ErrUnauthorized):
+		return fmt.Errorf("axiom token is not allowed to ingest into dataset %q: %w", name, err)
+	case errors.Is(err, axiom.ErrNotFound):
+		return d.missing(client, err)
+	}
+
+	return fmt.Errorf("can not ingest into dataset %q: %w", name, err)
+}
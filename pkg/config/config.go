@@ -0,0 +1,175 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/axiomhq/sentinelexport/pkg/monitor"
+	"github.com/axiomhq/sentinelexport/pkg/transform"
+)
+
+// Config is the file passed with --config. Flags and environment variables take
+// precedence over it.
+type Config struct {
+	Storage Storage          `yaml:"storage"`
+	Axiom   Axiom            `yaml:"axiom"`
+	Tables  map[string]Table `yaml:"tables"`
+}
+
+// Storage is the storage account Log Analytics Data Export exports to. An
+// exporter exports a single storage account, several accounts need an exporter
+// each.
+type Storage struct {
+	URL              string `yaml:"url"`
+	ConnectionString string `yaml:"connectionString"`
+	EventsQueue      string `yaml:"eventsQueue"`
+	QueueURL         string `yaml:"queueURL"`
+}
+
+// Axiom is where the tables are exported to.
+type Axiom struct {
+	URL              string `yaml:"url"`
+	Token            string `yaml:"token"`
+	Org              string `yaml:"org"`
+	DatasetPrefix    string `yaml:"datasetPrefix"`
+	NoCreateDatasets bool   `yaml:"noCreateDatasets"`
+}
+
+// Table is how the blobs of a table are exported, keyed by the name of the table.
+type Table struct {
+	// Dataset defaults to the name of the table, the dataset prefix is added
+	// to it either way.
+	Dataset string `yaml:"dataset"`
+	// TimestampField defaults to axm.DefaultTimestampField.
+	TimestampField string `yaml:"timestampField"`
+	// TimestampFormat is the Go time layout of the timestamp field, axiom
+	// detects the format if it isn't set.
+	TimestampFormat string `yaml:"timestampFormat"`
+	// TimestampFallbacks are the fields the timestamp is taken from, in order,
+	// for rows without the timestamp field.
+	TimestampFallbacks []string `yaml:"timestampFallbacks"`
+	// Enabled defaults to true, the blobs of a disabled table are left alone.
+	Enabled *bool `yaml:"enabled"`
+	// Concurrency is how many blobs of the table are shipped at once, it
+	// defaults to 1.
+	Concurrency int `yaml:"concurrency"`
+	// Transforms are applied to every row in order.
+	Transforms []transform.Transform `yaml:"transforms"`
+}
+
+func (t Table) IsEnabled() bool {
+	return t.Enabled == nil || *t.Enabled
+}
+
+// Load reads and validates the config file.
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not read config: %w", err)
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("can not parse config %q: %w", path, err)
+	}
+
+	cfg := &Config{}
+	if len(root.Content) == 0 {
+		// an empty file
+		return cfg, nil
+	}
+
+	if err := checkKeys(root.Content[0], "", cfg); err != nil {
+		return nil, fmt.Errorf("invalid config %q:\n%w", path, err)
+	}
+	if err := root.Decode(cfg); err != nil {
+		return nil, fmt.Errorf("invalid config %q: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config %q:\n%w", path, err)
+	}
+
+	return cfg, nil
+}
+
+// Table returns the settings of the table, which are the defaults if the table
+// isn't in the config.
+func (c *Config) Table(name string) Table {
+	if c == nil {
+		return Table{}
+	}
+	return c.Tables[name]
+}
+
+// any time but the reference time, a layout without any elements formats it to
+// the layout itself
+var layoutCheck = time.Date(1999, time.December, 31, 23, 58, 57, 0, time.UTC)
+
+var datasetName = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_.-]*$`)
+
+func (c *Config) Validate() error {
+	var errs []error
+
+	for key, value := range map[string]string{
+		"storage.url":      c.Storage.URL,
+		"storage.queueURL": c.Storage.QueueURL,
+		"axiom.url":        c.Axiom.URL,
+	} {
+		if value == "" {
+			continue
+		}
+		if u, err := url.Parse(value); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("%s: %q is not a valid url", key, value))
+		}
+	}
+
+	names := make([]string, 0, len(c.Tables))
+	for name := range c.Tables {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	for _, name := range names {
+		errs = append(errs, c.Tables[name].validate("tables."+name, name))
+	}
+
+	return errors.Join(errs...)
+}
+
+func (t Table) validate(key, name string) error {
+	if known, ok := monitor.KnownTable(name); !ok {
+		return fmt.Errorf("%s: unknown table, it must be a table Log Analytics Data Export can export", key)
+	} else if known != name {
+		return fmt.Errorf("%s: unknown table, did you mean %s?", key, known)
+	}
+
+	var errs []error
+	if t.Dataset != "" && !datasetName.MatchString(t.Dataset) {
+		errs = append(errs, fmt.Errorf("%s.dataset: %q is not a valid dataset name", key, t.Dataset))
+	}
+	if t.TimestampFormat != "" && layoutCheck.Format(t.TimestampFormat) == t.TimestampFormat {
+		errs = append(errs, fmt.Errorf("%s.timestampFormat: %q is not a Go time layout, e.g. %q", key, t.TimestampFormat, time.RFC3339))
+	}
+	for i, field := range t.TimestampFallbacks {
+		if field == "" {
+			errs = append(errs, fmt.Errorf("%s.timestampFallbacks[%d]: field can not be empty", key, i))
+		}
+	}
+	if t.Concurrency < 0 {
+		errs = append(errs, fmt.Errorf("%s.concurrency: can not be negative", key))
+	}
+	for i, tr := range t.Transforms {
+		if err := tr.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s.transforms[%d]: %w", key, i, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
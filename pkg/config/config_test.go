@@ -0,0 +1,138 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func load(t *testing.T, yaml string) (*Config, error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "sentinel-sync.yaml")
+	if err := os.WriteFile(path, []byte(yaml), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return Load(path)
+}
+
+func TestLoad(t *testing.T) {
+	cfg, err := load(t, `
+storage:
+  url: https://mystorage.blob.core.windows.net/
+  eventsQueue: sentinel-sync-events
+axiom:
+  datasetPrefix: az_
+  noCreateDatasets: true
+tables:
+  SecurityEvent:
+    dataset: windows-security
+    timestampField: TimeCreated
+    timestampFormat: "2006-01-02T15:04:05.999Z07:00"
+    timestampFallbacks: [EventTime, TimeGenerated]
+    concurrency: 4
+    transforms:
+      - drop: [TenantId]
+      - rename: {Computer: host}
+  AzureDevOpsAuditing:
+    enabled: false
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Storage.URL != "https://mystorage.blob.core.windows.net/" || cfg.Storage.EventsQueue != "sentinel-sync-events" {
+		t.Errorf("storage is %+v", cfg.Storage)
+	}
+	if cfg.Axiom.DatasetPrefix != "az_" || !cfg.Axiom.NoCreateDatasets {
+		t.Errorf("axiom is %+v", cfg.Axiom)
+	}
+
+	security := cfg.Table("SecurityEvent")
+	if security.Dataset != "windows-security" || security.TimestampField != "TimeCreated" || security.Concurrency != 4 || len(security.TimestampFallbacks) != 2 {
+		t.Errorf("SecurityEvent is %+v", security)
+	}
+	if len(security.Transforms) != 2 || security.Transforms[1].Rename["Computer"] != "host" {
+		t.Errorf("SecurityEvent transforms are %+v", security.Transforms)
+	}
+	if !security.IsEnabled() || cfg.Table("AzureDevOpsAuditing").IsEnabled() {
+		t.Error("only AzureDevOpsAuditing should be disabled")
+	}
+	if table := cfg.Table("SigninLogs"); !table.IsEnabled() || table.Dataset != "" {
+		t.Errorf("SigninLogs isn't the default: %+v", table)
+	}
+}
+
+func TestLoadEmpty(t *testing.T) {
+	cfg, err := load(t, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(cfg.Tables) != 0 || cfg.Storage != (Storage{}) {
+		t.Errorf("empty config is %+v", cfg)
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want []string
+	}{
+		{
+			"unknown keys",
+			"storage:\n  urls: x\naxiom:\n  token: xaat-0\nretries: 3\n",
+			[]string{"line 2: storage.urls: unknown key", "line 5: retries: unknown key"},
+		},
+		{
+			"unknown table key",
+			"tables:\n  SigninLogs:\n    datset: signins\n    transforms:\n      - drop: [a]\n        keep: [b]\n",
+			[]string{"line 3: tables.SigninLogs.datset: unknown key", "line 6: tables.SigninLogs.transforms[0].keep: unknown key"},
+		},
+		{
+			"storage accounts",
+			"storage:\n  - url: https://a.blob.core.windows.net/\n  - url: https://b.blob.core.windows.net/\n",
+			[]string{"line 2: storage: must be a single mapping, not a list"},
+		},
+		{
+			"wrong type",
+			"tables:\n  SigninLogs:\n    concurrency: many\n",
+			[]string{"cannot unmarshal"},
+		},
+		{
+			"invalid url",
+			"axiom:\n  url: api.axiom.co\n",
+			[]string{`axiom.url: "api.axiom.co" is not a valid url`},
+		},
+		{
+			"unknown table",
+			"tables:\n  SignInLogs: {}\n  NotATable: {}\n",
+			[]string{"tables.SignInLogs: unknown table, did you mean SigninLogs?", "tables.NotATable: unknown table"},
+		},
+		{
+			"invalid table settings",
+			"tables:\n  SigninLogs:\n    dataset: -signins\n    timestampFormat: iso\n    timestampFallbacks: ['']\n    concurrency: -1\n    transforms:\n      - {drop: [a], set: {b: 1}}\n",
+			[]string{
+				`tables.SigninLogs.dataset: "-signins" is not a valid dataset name`,
+				`tables.SigninLogs.timestampFormat: "iso" is not a Go time layout`,
+				"tables.SigninLogs.timestampFallbacks[0]: field can not be empty",
+				"tables.SigninLogs.concurrency: can not be negative",
+				"tables.SigninLogs.transforms[0]: must have exactly one of drop, rename, set or coalesce",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		_, err := load(t, tt.yaml)
+		if err == nil {
+			t.Errorf("%s: loaded", tt.name)
+			continue
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(err.Error(), want) {
+				t.Errorf("%s: error %q doesn't mention %q", tt.name, err, want)
+			}
+		}
+	}
+}
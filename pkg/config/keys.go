@@ -0,0 +1,72 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// checkKeys reports every key in the node that v has no field for, by its full
+// path so it can be found in the file. Values of the wrong type are left to
+// Decode to report.
+func checkKeys(node *yaml.Node, path string, v any) error {
+	return checkNode(node, path, reflect.TypeOf(v))
+}
+
+func checkNode(node *yaml.Node, path string, t reflect.Type) error {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if node.Kind == yaml.AliasNode {
+		node = node.Alias
+	}
+
+	var errs []error
+	switch {
+	case t.Kind() == reflect.Struct && node.Kind == yaml.MappingNode:
+		fields := map[string]reflect.Type{}
+		for i := 0; i < t.NumField(); i++ {
+			name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+			if name != "" && name != "-" {
+				fields[name] = t.Field(i).Type
+			}
+		}
+
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			ft, ok := fields[key.Value]
+			if !ok {
+				errs = append(errs, fmt.Errorf("line %d: %s: unknown key", key.Line, joinKey(path, key.Value)))
+				continue
+			}
+			errs = append(errs, checkNode(value, joinKey(path, key.Value), ft))
+		}
+
+	case t.Kind() == reflect.Struct && node.Kind == yaml.SequenceNode:
+		// e.g. a list of storage accounts, which Decode would only report as a
+		// type error
+		errs = append(errs, fmt.Errorf("line %d: %s: must be a single mapping, not a list", node.Line, path))
+
+	case t.Kind() == reflect.Map && node.Kind == yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			errs = append(errs, checkNode(node.Content[i+1], joinKey(path, node.Content[i].Value), t.Elem()))
+		}
+
+	case t.Kind() == reflect.Slice && node.Kind == yaml.SequenceNode:
+		for i, item := range node.Content {
+			errs = append(errs, checkNode(item, fmt.Sprintf("%s[%d]", path, i), t.Elem()))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+func joinKey(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
@@ -0,0 +1,87 @@
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"strings"
+)
+
+// Setup makes slog.Default log to w, at level and up, as json or text.
+// Durations are logged in seconds.
+func Setup(w io.Writer, level, format string) error {
+	var lvl slog.Level
+	if err := lvl.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("unknown log level %q, must be debug, info, warn or error", level)
+	}
+
+	opts := &slog.HandlerOptions{
+		Level: lvl,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Value.Kind() == slog.KindDuration {
+				return slog.Float64(a.Key, a.Value.Duration().Seconds())
+			}
+			return a
+		},
+	}
+
+	var h slog.Handler
+	switch strings.ToLower(format) {
+	case "json":
+		h = slog.NewJSONHandler(w, opts)
+	case "text":
+		h = slog.NewTextHandler(w, opts)
+	default:
+		return fmt.Errorf("unknown log format %q, must be json or text", format)
+	}
+
+	slog.SetDefault(slog.New(h))
+	return nil
+}
+
+// New returns the logger of a package. It logs through slog.Default as it is
+// when logging, so package loggers made before Setup is called pick it up.
+func New(component string) *slog.Logger {
+	return slog.New(&defaultHandler{}).With("component", component)
+}
+
+// defaultHandler hands every record to the handler of slog.Default, with the
+// attributes and groups it was given applied.
+type defaultHandler struct {
+	ops []func(slog.Handler) slog.Handler
+}
+
+func (h *defaultHandler) handler() slog.Handler {
+	handler := slog.Default().Handler()
+	for _, op := range h.ops {
+		handler = op(handler)
+	}
+	return handler
+}
+
+func (h *defaultHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return slog.Default().Handler().Enabled(ctx, level)
+}
+
+func (h *defaultHandler) Handle(ctx context.Context, r slog.Record) error {
+	return h.handler().Handle(ctx, r)
+}
+
+func (h *defaultHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler {
+		return handler.WithAttrs(attrs)
+	})
+}
+
+func (h *defaultHandler) WithGroup(name string) slog.Handler {
+	return h.with(func(handler slog.Handler) slog.Handler {
+		return handler.WithGroup(name)
+	})
+}
+
+func (h *defaultHandler) with(op func(slog.Handler) slog.Handler) *defaultHandler {
+	ops := make([]func(slog.Handler) slog.Handler, len(h.ops), len(h.ops)+1)
+	copy(ops, h.ops)
+	return &defaultHandler{ops: append(ops, op)}
+}
@@ -0,0 +1,200 @@
+package metrics
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "sentinel_sync"
+
+var (
+	BlobsProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "blobs_processed_total",
+		Help:      "Blobs exported completely.",
+	}, []string{"table"})
+
+	BytesDownloaded = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "bytes_downloaded_total",
+		Help:      "Bytes read from blobs, including blobs read again to retry them.",
+	}, []string{"table"})
+
+	RowsIngested = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rows_ingested_total",
+		Help:      "Rows axiom ingested.",
+	}, []string{"table"})
+
+	RowsFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rows_failed_total",
+		Help:      "Rows axiom rejected.",
+	}, []string{"table"})
+
+	BlobErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "blob_errors_total",
+		Help:      "Times exporting a blob, or listing the blobs of a table, failed.",
+	}, []string{"table"})
+
+	BlobDeleteFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "blob_delete_failures_total",
+		Help:      "Exported blobs that could not be deleted.",
+	}, []string{"table"})
+
+	IngestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ingest_duration_seconds",
+		Help:      "How long writing the rows of a blob took, including retries.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 12),
+	}, []string{"table"})
+
+	AuditFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "audit_failures_total",
+		Help:      "Audit events that could not be written.",
+	})
+
+	TableStale = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "table_stale",
+		Help:      "1 when no new blob has been seen in the container of the table for the stale period, otherwise 0.",
+	}, []string{"table"})
+
+	Backlog = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "backlog_blobs",
+		Help:      "Blobs waiting to be exported, as of the last listing.",
+	}, []string{"container", "table"})
+)
+
+// lag reports per table, as of when it is scraped rather than when the
+// container was listed, the age of the oldest blob waiting to be exported and how
+// far behind the export is.
+type lag struct {
+	oldestPendingDesc *prometheus.Desc
+	lagDesc           *prometheus.Desc
+	lastIngestDesc    *prometheus.Desc
+
+	mu     sync.Mutex
+	tables map[string]*tableLag
+}
+
+type tableLag struct {
+	// the time of the oldest blob waiting to be exported, zero when there is none
+	oldestPending time.Time
+	// the time of the newest blob ingested, and when it was
+	newestIngested time.Time
+	lastIngest     time.Time
+}
+
+// behind is the time the export of the table has got to.
+func (t *tableLag) behind() time.Time {
+	if !t.oldestPending.IsZero() {
+		return t.oldestPending
+	}
+	return t.newestIngested
+}
+
+var tables = &lag{
+	oldestPendingDesc: prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "oldest_pending_blob_age_seconds"),
+		"Age of the oldest blob waiting to be exported, going by the time in its name; 0 when there is none.",
+		[]string{"table"}, nil,
+	),
+	lagDesc: prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "lag_seconds"),
+		"How far behind the export of the table is: the age of the oldest blob waiting to be exported or, when there is none, of the newest blob ingested.",
+		[]string{"table"}, nil,
+	),
+	lastIngestDesc: prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "last_ingest_timestamp_seconds"),
+		"When rows of the table were last ingested, as a unix timestamp.",
+		[]string{"table"}, nil,
+	),
+	tables: map[string]*tableLag{},
+}
+
+func init() {
+	prometheus.MustRegister(tables)
+}
+
+func (c *lag) table(name string) *tableLag {
+	t, ok := c.tables[name]
+	if !ok {
+		t = &tableLag{}
+		c.tables[name] = t
+	}
+	return t
+}
+
+// SetOldestPending records the time of the oldest blob of the table waiting to
+// be exported, the zero time when there is none.
+func SetOldestPending(table string, t time.Time) {
+	tables.mu.Lock()
+	defer tables.mu.Unlock()
+
+	tables.table(table).oldestPending = t
+}
+
+// SetIngested records that rows of the table were just ingested from a blob
+// with the time in its name, which is the zero time if it has none.
+func SetIngested(table string, blobTime time.Time) {
+	tables.mu.Lock()
+	defer tables.mu.Unlock()
+
+	t := tables.table(table)
+	t.lastIngest = time.Now()
+	if blobTime.After(t.newestIngested) {
+		t.newestIngested = blobTime
+	}
+}
+
+// ForgetTable stops reporting the lag of a table whose container is gone.
+func ForgetTable(table string) {
+	tables.mu.Lock()
+	defer tables.mu.Unlock()
+
+	delete(tables.tables, table)
+}
+
+func (c *lag) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.oldestPendingDesc
+	ch <- c.lagDesc
+	ch <- c.lastIngestDesc
+}
+
+func (c *lag) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for name, t := range c.tables {
+		var age float64
+		if !t.oldestPending.IsZero() {
+			age = now.Sub(t.oldestPending).Seconds()
+		}
+		ch <- prometheus.MustNewConstMetric(c.oldestPendingDesc, prometheus.GaugeValue, age, name)
+
+		// until something is pending or ingested there is no telling
+		if behind := t.behind(); !behind.IsZero() {
+			ch <- prometheus.MustNewConstMetric(c.lagDesc, prometheus.GaugeValue, now.Sub(behind).Seconds(), name)
+		}
+
+		if !t.lastIngest.IsZero() {
+			ch <- prometheus.MustNewConstMetric(c.lastIngestDesc, prometheus.GaugeValue, float64(t.lastIngest.UnixNano())/1e9, name)
+		}
+	}
+}
+
+// Handler serves the metrics in the prometheus exposition format.
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
@@ -2,18 +2,26 @@ package monitor
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"regexp"
+	"slices"
 	"strconv"
 	"time"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/axiomhq/sentinelexport/pkg/source"
 )
 
 type Blob struct {
 	containerName string
 	blobName      string
+
+	// filled in from the listing, or by LoadProperties
+	propsLoaded  bool
+	lastModified time.Time
+	appendBlob   bool
+	size         int64
 }
 
 func newBlob(containerName, blobName string) *Blob {
@@ -23,6 +31,24 @@ func newBlob(containerName, blobName string) *Blob {
 	}
 }
 
+func newBlobFromProperties(containerName string, props *source.Properties) *Blob {
+	b := newBlob(containerName, props.Name)
+	b.setProperties(props)
+	return b
+}
+
+func (b *Blob) setProperties(props *source.Properties) {
+	b.propsLoaded = true
+	b.lastModified = props.LastModified
+	b.appendBlob = props.Append
+	b.size = props.Size
+}
+
+// Size is the size of the blob in bytes, as of when its properties were loaded.
+func (b *Blob) Size() int64 {
+	return b.size
+}
+
 func (b *Blob) ContainerName() string {
 	return b.containerName
 }
@@ -31,7 +57,11 @@ func (b *Blob) BlobName() string {
 	return b.blobName
 }
 
-var blobNameExtract = regexp.MustCompile(`(?:y=(?P<year>\d+))/(?:m=(?P<month>\d+))/(?:d=(?P<day>\d+))/(?:h=(?P<hour>\d+))/(?:m=(?P<minute>\d+))/\w+(_\d+)?.json`)
+// ErrInvalidBlobName is returned for blobs whose name doesn't follow the Data
+// Export layout, so they can't be ordered.
+var ErrInvalidBlobName = errors.New("invalid blob name")
+
+var blobNameExtract = regexp.MustCompile(`(?:y=(?P<year>\d+))/(?:m=(?P<month>\d+))/(?:d=(?P<day>\d+))/(?:h=(?P<hour>\d+))/(?:m=(?P<minute>\d+))/[[:alnum:]]+(?:_(\d+))?\.json`)
 
 func (b *Blob) Date() (t time.Time, err error) {
 	// Blobs are stored in 5-minute folders in the following path structure:
@@ -39,7 +69,7 @@ func (b *Blob) Date() (t time.Time, err error) {
 
 	matches := blobNameExtract.FindStringSubmatch(b.blobName)
 	if len(matches) < 6 {
-		return time.Time{}, fmt.Errorf("invalid blob name: %q", b.blobName)
+		return time.Time{}, fmt.Errorf("%w: %q", ErrInvalidBlobName, b.blobName)
 	}
 
 	year, err := strconv.Atoi(matches[1])
@@ -80,6 +110,30 @@ func (b *Blob) Date() (t time.Time, err error) {
 	return time.Date(year, time.Month(month), day, hour, minute, 0, nanoseconds, time.UTC), nil
 }
 
+// SortBlobs sorts blobs with valid names oldest first. Listings are sorted by
+// name, which puts PT05M_10.json before PT05M_2.json.
+func SortBlobs(blobs []*Blob) {
+	type dated struct {
+		blob *Blob
+		date time.Time
+	}
+
+	// parse every name once rather than on every comparison
+	sorted := make([]dated, len(blobs))
+	for i, b := range blobs {
+		sorted[i].blob = b
+		sorted[i].date, _ = b.Date()
+	}
+
+	slices.SortStableFunc(sorted, func(a, b dated) int {
+		return a.date.Compare(b.date)
+	})
+
+	for i := range sorted {
+		blobs[i] = sorted[i].blob
+	}
+}
+
 func (b *Blob) Before(test *Blob) (bool, error) {
 	bTime, err := b.Date()
 	if err != nil {
@@ -94,20 +148,27 @@ func (b *Blob) Before(test *Blob) (bool, error) {
 	return bTime.Before(testTime), nil
 }
 
-func (b *Blob) Stream(ctx context.Context, client *azblob.Client) (io.ReadCloser, error) {
-	resp, err := client.DownloadStream(ctx, b.containerName, b.blobName, nil)
+// LoadProperties fetches the properties the listing would have returned, for
+// blobs that weren't found by listing.
+func (b *Blob) LoadProperties(ctx context.Context, src source.Source) error {
+	props, err := src.Properties(ctx, b.containerName, b.blobName)
 	if err != nil {
-		return nil, fmt.Errorf("can not download blob container=%q, name=%q: %w", b.containerName, b.blobName, err)
+		return err
 	}
 
-	return resp.Body, nil
+	b.setProperties(props)
+	return nil
 }
 
-func (b *Blob) Delete(ctx context.Context, client *azblob.Client) error {
-	_, err := client.DeleteBlob(ctx, b.containerName, b.blobName, nil)
-	if err != nil {
-		return fmt.Errorf("can not delete blob %q: %w", b.blobName, err)
-	}
+func (b *Blob) Stream(ctx context.Context, src source.Source) (io.ReadCloser, error) {
+	return b.StreamFrom(ctx, src, 0)
+}
 
-	return nil
+// StreamFrom streams the blob starting at the byte offset.
+func (b *Blob) StreamFrom(ctx context.Context, src source.Source, offset int64) (io.ReadCloser, error) {
+	return src.Open(ctx, b.containerName, b.blobName, offset)
+}
+
+func (b *Blob) Delete(ctx context.Context, src source.Source) error {
+	return src.Delete(ctx, b.containerName, b.blobName)
 }
@@ -0,0 +1,44 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+)
+
+const folder = "WorkspaceResourceId=/subscriptions/0/resourcegroups/rg/providers/microsoft.operationalinsights/workspaces/ws/y=2024/m=01/d=31/h=23/m=50/"
+
+func TestBlobDate(t *testing.T) {
+	window := time.Date(2024, 1, 31, 23, 50, 0, 0, time.UTC)
+
+	for name, want := range map[string]time.Time{
+		"PT05M.json":    window,
+		"PT05M_2.json":  window.Add(2),
+		"PT05M_10.json": window.Add(10),
+	} {
+		got, err := newBlob("am-signinlogs", folder+name).Date()
+		if err != nil {
+			t.Errorf("%s: %v", name, err)
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("%s is dated %s, want %s", name, got, want)
+		}
+	}
+}
+
+func TestSortBlobs(t *testing.T) {
+	// listed by name
+	var blobs []*Blob
+	for _, name := range []string{"PT05M.json", "PT05M_1.json", "PT05M_10.json", "PT05M_2.json"} {
+		blobs = append(blobs, newBlob("am-signinlogs", folder+name))
+	}
+
+	SortBlobs(blobs)
+
+	want := []string{"PT05M.json", "PT05M_1.json", "PT05M_2.json", "PT05M_10.json"}
+	for i, blob := range blobs {
+		if blob.BlobName() != folder+want[i] {
+			t.Errorf("blob %d is %q, want %q", i, blob.BlobName(), folder+want[i])
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"time"
+
+	"github.com/axiomhq/sentinelexport/pkg/source"
+)
+
+// Checkpoint records the newest blob of a container that has been shipped. Blobs
+// are shipped oldest to newest, so every blob at or before it has been shipped too.
+type Checkpoint struct {
+	BlobName  string    `json:"blobName"`
+	BlobTime  time.Time `json:"blobTime"`
+	UpdatedAt time.Time `json:"updatedAt"`
+
+	// Partial is the blob after BlobName that is still being appended to, when
+	// tailing blobs it has been shipped up to Offset.
+	Partial *PartialCheckpoint `json:"partial,omitempty"`
+
+	// Skipped are blobs that were moved to the dead-letter container but can't
+	// be covered by BlobTime, as their name has no time in it.
+	Skipped []string `json:"skipped,omitempty"`
+
+	// Shipped are blobs after BlobTime that were shipped while an older blob
+	// failed, so the checkpoint couldn't move past them yet.
+	Shipped []string `json:"shipped,omitempty"`
+}
+
+type PartialCheckpoint struct {
+	BlobName string `json:"blobName"`
+	Offset   int64  `json:"offset"`
+
+	// Failed is set when tailing the blob failed because of the blob itself, it
+	// isn't tailed anymore and is shipped from Offset once it settles.
+	Failed bool `json:"failed,omitempty"`
+}
+
+// Offset returns how many bytes of the blob have already been shipped.
+func (cp *Checkpoint) Offset(blob *Blob) int64 {
+	if cp == nil || cp.Partial == nil || cp.Partial.BlobName != blob.blobName {
+		return 0
+	}
+
+	return cp.Partial.Offset
+}
+
+// TailFailed reports whether tailing the blob failed because of the blob itself.
+func (cp *Checkpoint) TailFailed(blob *Blob) bool {
+	return cp != nil && cp.Partial != nil && cp.Partial.BlobName == blob.blobName && cp.Partial.Failed
+}
+
+// Covers reports whether the blob has already been shipped.
+func (cp *Checkpoint) Covers(blob *Blob) (bool, error) {
+	if cp == nil {
+		return false, nil
+	}
+
+	if slices.Contains(cp.Skipped, blob.blobName) || slices.Contains(cp.Shipped, blob.blobName) {
+		return true, nil
+	}
+
+	bTime, err := blob.Date()
+	if err != nil {
+		return false, err
+	}
+
+	return !bTime.After(cp.BlobTime), nil
+}
+
+// CheckpointStore keeps one checkpoint blob per am- container in its own
+// container, so blobs can be kept where they are instead of being deleted.
+type CheckpointStore struct {
+	containerName string
+}
+
+func NewCheckpointStore(containerName string) *CheckpointStore {
+	if containerName == "" {
+		panic("container name can not be empty")
+	}
+
+	return &CheckpointStore{
+		containerName: containerName,
+	}
+}
+
+func (s *CheckpointStore) ContainerName() string {
+	return s.containerName
+}
+
+// Ensure creates the checkpoint container if it doesn't exist yet.
+func (s *CheckpointStore) Ensure(ctx context.Context, src source.Source) error {
+	if err := src.CreateContainer(ctx, s.containerName); err != nil {
+		return fmt.Errorf("can not create checkpoint container %q: %w", s.containerName, err)
+	}
+
+	return nil
+}
+
+// Get returns the checkpoint of the container, or nil if nothing was shipped yet.
+func (s *CheckpointStore) Get(ctx context.Context, src source.Source, containerName string) (*Checkpoint, error) {
+	r, err := src.Open(ctx, s.containerName, checkpointBlobName(containerName), 0)
+	if errors.Is(err, source.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can not download checkpoint container=%q: %w", containerName, err)
+	}
+	defer r.Close()
+
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("can not read checkpoint container=%q: %w", containerName, err)
+	}
+
+	var cp Checkpoint
+	if err := json.Unmarshal(body, &cp); err != nil {
+		return nil, fmt.Errorf("can not decode checkpoint container=%q: %w", containerName, err)
+	}
+
+	return &cp, nil
+}
+
+// Set moves the checkpoint of the blob's container forward to the blob, cp may
+// be nil if no blob of the container was shipped yet.
+func (s *CheckpointStore) Set(ctx context.Context, src source.Source, cp *Checkpoint, blob *Blob) (*Checkpoint, error) {
+	bTime, err := blob.Date()
+	if err != nil {
+		return nil, err
+	}
+
+	next := &Checkpoint{
+		BlobName:  blob.blobName,
+		BlobTime:  bTime,
+		UpdatedAt: time.Now().UTC(),
+	}
+	if cp != nil {
+		next.Skipped = cp.Skipped
+		// the blobs the checkpoint has caught up with are covered by its time
+		for _, name := range cp.Shipped {
+			if shipped, err := (&Blob{blobName: name}).Date(); err != nil || shipped.After(bTime) {
+				next.Shipped = append(next.Shipped, name)
+			}
+		}
+	}
+
+	return next, s.put(ctx, src, blob.containerName, next)
+}
+
+// Skip records that the blob should never be shipped, cp may be nil if no blob
+// of the container was shipped yet.
+func (s *CheckpointStore) Skip(ctx context.Context, src source.Source, cp *Checkpoint, blob *Blob) (*Checkpoint, error) {
+	next := &Checkpoint{}
+	if cp != nil {
+		*next = *cp
+	}
+	next.UpdatedAt = time.Now().UTC()
+	next.Skipped = append(slices.Clip(next.Skipped), blob.blobName)
+
+	return next, s.put(ctx, src, blob.containerName, next)
+}
+
+// SetShipped records that the blob was shipped while an older blob of its
+// container failed, so the checkpoint can't move forward to it yet.
+func (s *CheckpointStore) SetShipped(ctx context.Context, src source.Source, cp *Checkpoint, blob *Blob) (*Checkpoint, error) {
+	next := &Checkpoint{}
+	if cp != nil {
+		*next = *cp
+	}
+	next.UpdatedAt = time.Now().UTC()
+	next.Shipped = append(slices.Clip(next.Shipped), blob.blobName)
+
+	return next, s.put(ctx, src, blob.containerName, next)
+}
+
+// SetPartial records that the blob following the checkpoint has been shipped up
+// to offset, cp may be nil if no blob of the container was shipped yet.
+func (s *CheckpointStore) SetPartial(ctx context.Context, src source.Source, cp *Checkpoint, blob *Blob, offset int64) (*Checkpoint, error) {
+	next := partial(cp, blob, offset)
+	return next, s.put(ctx, src, blob.containerName, next)
+}
+
+// FailPartial records that tailing the blob following the checkpoint failed
+// because of the blob itself, so it isn't tailed anymore. The offset it was
+// shipped up to stays as it was.
+func (s *CheckpointStore) FailPartial(ctx context.Context, src source.Source, cp *Checkpoint, blob *Blob) (*Checkpoint, error) {
+	next := partial(cp, blob, cp.Offset(blob))
+	next.Partial.Failed = true
+
+	return next, s.put(ctx, src, blob.containerName, next)
+}
+
+func partial(cp *Checkpoint, blob *Blob, offset int64) *Checkpoint {
+	next := &Checkpoint{
+		UpdatedAt: time.Now().UTC(),
+		Partial: &PartialCheckpoint{
+			BlobName: blob.blobName,
+			Offset:   offset,
+		},
+	}
+	if cp != nil {
+		next.BlobName = cp.BlobName
+		next.BlobTime = cp.BlobTime
+		next.Skipped = cp.Skipped
+		next.Shipped = cp.Shipped
+	}
+
+	return next
+}
+
+func (s *CheckpointStore) put(ctx context.Context, src source.Source, containerName string, cp *Checkpoint) error {
+	body, err := json.Marshal(cp)
+	if err != nil {
+		return err
+	}
+
+	err = src.Upload(ctx, s.containerName, checkpointBlobName(containerName), bytes.NewReader(body), nil)
+	if err != nil {
+		return fmt.Errorf("can not upload checkpoint container=%q: %w", containerName, err)
+	}
+
+	return nil
+}
+
+func checkpointBlobName(containerName string) string {
+	return containerName + ".json"
+}
+
+// AuditChain is the last event written to an audit dataset, so the next run of
+// the exporter carries on with its chain.
+type AuditChain struct {
+	RunID     string    `json:"runId"`
+	Sequence  uint64    `json:"sequence"`
+	Hash      string    `json:"hash"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// GetAuditChain returns the last event written to the audit dataset, or nil if
+// none was written yet.
+func (s *CheckpointStore) GetAuditChain(ctx context.Context, src source.Source, dataset string) (*AuditChain, error) {
+	r, err := src.Open(ctx, s.containerName, auditChainBlobName(dataset), 0)
+	if errors.Is(err, source.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can not download audit chain dataset=%q: %w", dataset, err)
+	}
+	defer r.Close()
+
+	var chain AuditChain
+	if err := json.NewDecoder(r).Decode(&chain); err != nil {
+		return nil, fmt.Errorf("can not decode audit chain dataset=%q: %w", dataset, err)
+	}
+
+	return &chain, nil
+}
+
+// SetAuditChain records the last event written to the audit dataset.
+func (s *CheckpointStore) SetAuditChain(ctx context.Context, src source.Source, dataset string, chain *AuditChain) error {
+	chain.UpdatedAt = time.Now().UTC()
+	body, err := json.Marshal(chain)
+	if err != nil {
+		return err
+	}
+
+	err = src.Upload(ctx, s.containerName, auditChainBlobName(dataset), bytes.NewReader(body), nil)
+	if err != nil {
+		return fmt.Errorf("can not upload audit chain dataset=%q: %w", dataset, err)
+	}
+
+	return nil
+}
+
+// the checkpoints of the containers are named after them, and every container
+// starts with am-, so these can't clash
+func auditChainBlobName(dataset string) string {
+	return "audit-" + dataset + ".json"
+}
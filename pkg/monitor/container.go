@@ -2,10 +2,12 @@ package monitor
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"strings"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	"github.com/axiomhq/axiom-go/axiom"
+	"github.com/axiomhq/sentinelexport/pkg/source"
 )
 
 type ContainerMonitor struct {
@@ -32,49 +34,69 @@ func (c *ContainerMonitor) TableName() string {
 	return ContainerNameToTable(c.name)
 }
 
-func (c *ContainerMonitor) HasBlobs(ctx context.Context, client *azblob.Client) (bool, error) {
-	_, more, err := c.GetNextBlob(ctx, client)
-	return more, err
+// IsKnownTable reports whether the container is of a table Log Analytics Data
+// Export is known to export, rather than one TableName made up from the name.
+func (c *ContainerMonitor) IsKnownTable() bool {
+	_, ok := KnownTable(strings.TrimPrefix(c.name, amPrefix))
+	return ok
 }
 
-func (c *ContainerMonitor) GetNextBlob(ctx context.Context, client *azblob.Client) (blob *Blob, more bool, err error) {
-	pager := client.NewListBlobsFlatPager(c.name, nil)
-	foundBlobs := 0
-	for pager.More() {
-		page, perr := pager.NextPage(ctx)
-		if perr != nil {
-			err = fmt.Errorf("can not get next page: %w", perr)
-			return
+func (c *ContainerMonitor) HasBlobs(ctx context.Context, src source.Source) (bool, error) {
+	blobs, err := c.ListPendingBlobs(ctx, src, nil)
+	return len(blobs) > 0, err
+}
+
+// ListPendingBlobs lists the container once and returns the blobs that aren't
+// covered by the checkpoint in the order they must be shipped, oldest first. A
+// nil checkpoint means every blob is pending. Blobs with an invalid name can't be
+// ordered, so they come first.
+func (c *ContainerMonitor) ListPendingBlobs(ctx context.Context, src source.Source, after *Checkpoint) ([]*Blob, error) {
+	blobs, err := c.ListBlobs(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+
+	var invalid, pending []*Blob
+	for _, b := range blobs {
+		covered, err := after.Covers(b)
+		if err != nil && !errors.Is(err, ErrInvalidBlobName) {
+			return nil, err
+		}
+		if covered {
+			continue
 		}
 
-		for _, item := range page.Segment.BlobItems {
-			foundBlobs++
-			b := newBlob(c.name, *item.Name)
-
-			if blob == nil {
-				blob = b
-			} else {
-				older, err := b.Before(blob)
-				if err != nil {
-					return nil, false, err
-				}
-				if older {
-					blob = b
-				}
-			}
+		if _, err := b.Date(); err != nil {
+			invalid = append(invalid, b)
+			continue
 		}
+		pending = append(pending, b)
+	}
+
+	SortBlobs(pending)
+	return append(invalid, pending...), nil
+}
+
+// ListBlobs returns every blob in the container, in listing order.
+func (c *ContainerMonitor) ListBlobs(ctx context.Context, src source.Source) (blobs []*Blob, err error) {
+	items, err := src.ListBlobs(ctx, c.name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, item := range items {
+		blobs = append(blobs, newBlobFromProperties(c.name, item))
 	}
 
-	more = foundBlobs > 1
-	return
+	return blobs, nil
 }
 
-func (c *ContainerMonitor) StreamBlob(ctx context.Context, client *azblob.Client, axiClient *axiom.Client, blob *Blob) (err error) {
-	resp, err := client.DownloadStream(ctx, c.name, blob.blobName, nil)
+func (c *ContainerMonitor) StreamBlob(ctx context.Context, src source.Source, axiClient *axiom.Client, blob *Blob) (err error) {
+	body, err := blob.Stream(ctx, src)
 	if err != nil {
 		return fmt.Errorf("can not download blob: %w", err)
 	}
 
-	defer resp.Body.Close()
+	defer body.Close()
 	return nil
 }
@@ -0,0 +1,111 @@
+package monitor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/axiomhq/axiom-go/axiom/ingest"
+	"github.com/axiomhq/sentinelexport/pkg/source"
+)
+
+// DeadLetterStore keeps the blobs and rows that could not be ingested in their own
+// container, under the name of the container and blob they came from. Why they
+// ended up there is kept in the blob metadata.
+type DeadLetterStore struct {
+	containerName string
+}
+
+func NewDeadLetterStore(containerName string) *DeadLetterStore {
+	if containerName == "" {
+		panic("container name can not be empty")
+	}
+
+	return &DeadLetterStore{
+		containerName: containerName,
+	}
+}
+
+func (s *DeadLetterStore) ContainerName() string {
+	return s.containerName
+}
+
+// Ensure creates the dead-letter container if it doesn't exist yet.
+func (s *DeadLetterStore) Ensure(ctx context.Context, src source.Source) error {
+	if err := src.CreateContainer(ctx, s.containerName); err != nil {
+		return fmt.Errorf("can not create dead-letter container %q: %w", s.containerName, err)
+	}
+
+	return nil
+}
+
+// PutBlob copies the whole blob, after it failed to ship attempts times.
+func (s *DeadLetterStore) PutBlob(ctx context.Context, src source.Source, blob *Blob, cause error, attempts int) error {
+	body, err := blob.Stream(ctx, src)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+
+	name := fmt.Sprintf("%s/%s", blob.containerName, blob.blobName)
+	err = src.Upload(ctx, s.containerName, name, body, deadLetterMetadata(cause.Error(), attempts))
+	if err != nil {
+		return fmt.Errorf("can not upload dead-letter blob %q: %w", blob.blobName, err)
+	}
+
+	return nil
+}
+
+// PutRows stores the rows of the blob, starting at offset, that failed to ingest
+// along with the ingest status explaining why.
+func (s *DeadLetterStore) PutRows(ctx context.Context, src source.Source, blob *Blob, offset int64, rows []byte, status *ingest.Status) error {
+	name := fmt.Sprintf("%s/%s.%d", blob.containerName, blob.blobName, offset)
+
+	var cause string
+	if len(status.Failures) > 0 {
+		cause = status.Failures[0].Error
+	}
+	metadata := deadLetterMetadata(fmt.Sprintf("%d rows failed to ingest: %s", status.Failed, cause), 1)
+
+	err := src.Upload(ctx, s.containerName, name+".rows.json", bytes.NewReader(rows), metadata)
+	if err != nil {
+		return fmt.Errorf("can not upload dead-letter rows for blob %q: %w", blob.blobName, err)
+	}
+
+	body, err := json.Marshal(status)
+	if err != nil {
+		return err
+	}
+
+	err = src.Upload(ctx, s.containerName, name+".status.json", bytes.NewReader(body), metadata)
+	if err != nil {
+		return fmt.Errorf("can not upload dead-letter status for blob %q: %w", blob.blobName, err)
+	}
+
+	return nil
+}
+
+// metadata values are sent as headers, so they have to be printable ascii
+const maxMetadataValue = 1024
+
+func deadLetterMetadata(cause string, attempts int) map[string]string {
+	cause = strings.Map(func(r rune) rune {
+		if r < ' ' || r > '~' {
+			return ' '
+		}
+		return r
+	}, cause)
+	if len(cause) > maxMetadataValue {
+		cause = cause[:maxMetadataValue]
+	}
+
+	return map[string]string{
+		"error":          cause,
+		"attempts":       strconv.Itoa(attempts),
+		"deadletteredat": time.Now().UTC().Format(time.RFC3339),
+	}
+}
@@ -0,0 +1,100 @@
+package monitor
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// TableFilter picks the tables to export by their name, as returned by
+// ContainerNameToTable. A pattern is a glob, or a regular expression when it is
+// wrapped in slashes, e.g. /^Azure/. Both ignore case.
+type TableFilter struct {
+	include []tablePattern
+	exclude []tablePattern
+}
+
+type tablePattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+// NewTableFilter returns a filter matching the tables that match any of the
+// include patterns, or every table if there are none, and none of the exclude
+// patterns.
+func NewTableFilter(include, exclude []string) (*TableFilter, error) {
+	var (
+		f   TableFilter
+		err error
+	)
+
+	if f.include, err = parseTablePatterns(include); err != nil {
+		return nil, err
+	}
+	if f.exclude, err = parseTablePatterns(exclude); err != nil {
+		return nil, err
+	}
+
+	return &f, nil
+}
+
+func parseTablePatterns(patterns []string) ([]tablePattern, error) {
+	var parsed []tablePattern
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+
+		if len(pattern) > 2 && strings.HasPrefix(pattern, "/") && strings.HasSuffix(pattern, "/") {
+			re, err := regexp.Compile("(?i)" + pattern[1:len(pattern)-1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid table regex %q: %w", pattern, err)
+			}
+			parsed = append(parsed, tablePattern{re: re})
+			continue
+		}
+
+		glob := strings.ToLower(pattern)
+		if _, err := path.Match(glob, ""); err != nil {
+			return nil, fmt.Errorf("invalid table glob %q: %w", pattern, err)
+		}
+		parsed = append(parsed, tablePattern{glob: glob})
+	}
+
+	return parsed, nil
+}
+
+func (p tablePattern) match(table string) bool {
+	if p.re != nil {
+		return p.re.MatchString(table)
+	}
+
+	ok, _ := path.Match(p.glob, strings.ToLower(table))
+	return ok
+}
+
+// Match reports whether the table should be exported, a nil filter matches every
+// table.
+func (f *TableFilter) Match(table string) bool {
+	if f == nil {
+		return true
+	}
+
+	for _, p := range f.exclude {
+		if p.match(table) {
+			return false
+		}
+	}
+
+	if len(f.include) == 0 {
+		return true
+	}
+	for _, p := range f.include {
+		if p.match(table) {
+			return true
+		}
+	}
+	return false
+}
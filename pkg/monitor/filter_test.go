@@ -0,0 +1,64 @@
+package monitor
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTableFilter(t *testing.T) {
+	tests := []struct {
+		name             string
+		include, exclude string
+		match, skip      []string
+	}{
+		{"no patterns", "", "", []string{"SigninLogs", "SecurityEvent"}, nil},
+		{"include glob", "Azure*", "", []string{"AzureActivity", "azuremetrics"}, []string{"SigninLogs", "MicrosoftAzureBastionAuditLogs"}},
+		{"include list", "SigninLogs, AuditLogs", "", []string{"SigninLogs", "auditlogs"}, []string{"SecurityEvent"}},
+		{"exclude glob", "", "*Logs", []string{"SecurityEvent"}, []string{"SigninLogs", "AuditLogs"}},
+		{"exclude wins", "Azure*", "AzureMetrics", []string{"AzureActivity"}, []string{"AzureMetrics", "SigninLogs"}},
+		{"include regex", "/^(Signin|Audit)Logs$/", "", []string{"SigninLogs", "AuditLogs", "auditlogs"}, []string{"AADNonInteractiveUserSignInLogs", "SigninLogsArchive"}},
+		{"unanchored regex", "/Signin/", "", []string{"SigninLogs", "AADNonInteractiveUserSignInLogs"}, []string{"AuditLogs"}},
+		{"exclude regex", "", "/^AAD/", []string{"SigninLogs"}, []string{"AADNonInteractiveUserSignInLogs"}},
+		{"single character glob", "Audit?ogs", "", []string{"AuditLogs"}, []string{"AuditLog"}},
+		{"slash is a glob", "/", "", nil, []string{"SigninLogs"}},
+	}
+
+	for _, tt := range tests {
+		f, err := NewTableFilter(strings.Split(tt.include, ","), strings.Split(tt.exclude, ","))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		for _, table := range tt.match {
+			if !f.Match(table) {
+				t.Errorf("%s: %s didn't match", tt.name, table)
+			}
+		}
+		for _, table := range tt.skip {
+			if f.Match(table) {
+				t.Errorf("%s: %s matched", tt.name, table)
+			}
+		}
+	}
+}
+
+func TestTableFilterNil(t *testing.T) {
+	var f *TableFilter
+	if !f.Match("SigninLogs") {
+		t.Error("nil filter didn't match")
+	}
+}
+
+func TestTableFilterInvalid(t *testing.T) {
+	for _, tt := range []struct {
+		include, exclude []string
+		want             string
+	}{
+		{[]string{"/(/"}, nil, `invalid table regex "/(/"`},
+		{nil, []string{"Azure["}, `invalid table glob "Azure["`},
+	} {
+		if _, err := NewTableFilter(tt.include, tt.exclude); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("include %q, exclude %q: error %v, want %s", tt.include, tt.exclude, err, tt.want)
+		}
+	}
+}
@@ -9,12 +9,21 @@ var amPrefix = "am-"
 
 func ContainerNameToTable(containerName string) string {
 	containerName = strings.TrimPrefix(containerName, amPrefix)
-	if i := slices.IndexFunc(knownTables, func(s string) bool { return strings.EqualFold(containerName, s) }); i >= 0 {
-		return knownTables[i]
+	if table, ok := KnownTable(containerName); ok {
+		return table
 	}
 	return containerName
 }
 
+// KnownTable returns the name of the table Log Analytics Data Export can export
+// that matches name, ignoring case.
+func KnownTable(name string) (string, bool) {
+	if i := slices.IndexFunc(knownTables, func(s string) bool { return strings.EqualFold(name, s) }); i >= 0 {
+		return knownTables[i], true
+	}
+	return "", false
+}
+
 var knownTables = []string{
 	"AACAudit",
 	"AACHttpRequest",
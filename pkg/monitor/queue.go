@@ -0,0 +1,127 @@
+package monitor
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azqueue"
+)
+
+const blobCreatedEventType = "Microsoft.Storage.BlobCreated"
+
+// QueueMonitor reads blob created notifications that an Event Grid subscription
+// delivers into an Azure Storage Queue.
+type QueueMonitor struct {
+	client *azqueue.QueueClient
+}
+
+func NewQueueMonitor(client *azqueue.QueueClient) *QueueMonitor {
+	return &QueueMonitor{
+		client: client,
+	}
+}
+
+// BlobEvent is a blob that was created in one of the am- containers. It must be
+// acked once it has been scheduled, otherwise it will be redelivered.
+type BlobEvent struct {
+	Blob *Blob
+
+	messageID  string
+	popReceipt string
+}
+
+// eventGridEvent covers both the Event Grid and the CloudEvents schema, they only
+// differ in the name of the event type field.
+type eventGridEvent struct {
+	EventType string `json:"eventType"`
+	Type      string `json:"type"`
+	Subject   string `json:"subject"`
+}
+
+func (q *QueueMonitor) Receive(ctx context.Context) ([]*BlobEvent, error) {
+	resp, err := q.client.DequeueMessages(ctx, &azqueue.DequeueMessagesOptions{
+		NumberOfMessages:  to.Ptr(int32(32)),
+		VisibilityTimeout: to.Ptr(int32(300)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can not dequeue messages: %w", err)
+	}
+
+	var events []*BlobEvent
+	for _, msg := range resp.Messages {
+		if msg.MessageID == nil || msg.PopReceipt == nil {
+			continue
+		}
+
+		ev := &BlobEvent{
+			messageID:  *msg.MessageID,
+			popReceipt: *msg.PopReceipt,
+		}
+
+		var text string
+		if msg.MessageText != nil {
+			text = *msg.MessageText
+		}
+
+		ev.Blob, err = parseBlobEvent(text)
+		if err != nil || ev.Blob == nil {
+			// the message is either not a blob created event or not for an
+			// exported table; it will never be useful, so drop it
+			if err := q.Ack(ctx, ev); err != nil {
+				return events, err
+			}
+			continue
+		}
+
+		events = append(events, ev)
+	}
+
+	return events, nil
+}
+
+func (q *QueueMonitor) Ack(ctx context.Context, ev *BlobEvent) error {
+	_, err := q.client.DeleteMessage(ctx, ev.messageID, ev.popReceipt, nil)
+	if err != nil {
+		return fmt.Errorf("can not delete message %q: %w", ev.messageID, err)
+	}
+
+	return nil
+}
+
+func parseBlobEvent(text string) (*Blob, error) {
+	// Event Grid base64 encodes the messages it delivers to storage queues
+	body := []byte(text)
+	if decoded, err := base64.StdEncoding.DecodeString(text); err == nil {
+		body = decoded
+	}
+
+	var ev eventGridEvent
+	if err := json.Unmarshal(body, &ev); err != nil {
+		return nil, fmt.Errorf("can not decode event: %w", err)
+	}
+
+	if ev.EventType != blobCreatedEventType && ev.Type != blobCreatedEventType {
+		return nil, nil
+	}
+
+	// subjects look like /blobServices/default/containers/<container>/blobs/<blob>
+	_, path, ok := strings.Cut(ev.Subject, "/containers/")
+	if !ok {
+		return nil, fmt.Errorf("invalid event subject: %q", ev.Subject)
+	}
+
+	containerName, blobName, ok := strings.Cut(path, "/blobs/")
+	if !ok || containerName == "" || blobName == "" {
+		return nil, fmt.Errorf("invalid event subject: %q", ev.Subject)
+	}
+
+	if !strings.HasPrefix(containerName, amPrefix) {
+		return nil, nil
+	}
+
+	return newBlob(containerName, blobName), nil
+}
@@ -0,0 +1,50 @@
+package monitor
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestParseBlobEvent(t *testing.T) {
+	const (
+		subject = "/blobServices/default/containers/am-signinlogs/blobs/" + folder + "PT05M.json"
+		created = `{"eventType":"Microsoft.Storage.BlobCreated","subject":"` + subject + `"}`
+	)
+
+	tests := []struct {
+		name string
+		text string
+		// the blob the event is for, "" when it is ignored
+		blob    string
+		invalid bool
+	}{
+		{name: "event grid", text: created, blob: folder + "PT05M.json"},
+		{name: "base64", text: base64.StdEncoding.EncodeToString([]byte(created)), blob: folder + "PT05M.json"},
+		{name: "cloud events", text: `{"type":"Microsoft.Storage.BlobCreated","subject":"` + subject + `"}`, blob: folder + "PT05M.json"},
+		{name: "other event", text: `{"eventType":"Microsoft.Storage.BlobDeleted","subject":"` + subject + `"}`},
+		{name: "foreign container", text: `{"eventType":"Microsoft.Storage.BlobCreated","subject":"/blobServices/default/containers/insights-logs/blobs/x.json"}`},
+		{name: "not json", text: "hello", invalid: true},
+		{name: "base64 not json", text: base64.StdEncoding.EncodeToString([]byte("hello")), invalid: true},
+		{name: "no container", text: `{"eventType":"Microsoft.Storage.BlobCreated","subject":"/blobServices/default"}`, invalid: true},
+		{name: "no blob", text: `{"eventType":"Microsoft.Storage.BlobCreated","subject":"/blobServices/default/containers/am-signinlogs/blobs/"}`, invalid: true},
+	}
+
+	for _, tt := range tests {
+		blob, err := parseBlobEvent(tt.text)
+		if tt.invalid != (err != nil) {
+			t.Errorf("%s: error %v, want invalid=%t", tt.name, err, tt.invalid)
+			continue
+		}
+
+		var got string
+		if blob != nil {
+			if blob.ContainerName() != "am-signinlogs" {
+				t.Errorf("%s: blob of container %q", tt.name, blob.ContainerName())
+			}
+			got = blob.BlobName()
+		}
+		if got != tt.blob {
+			t.Errorf("%s: blob %q, want %q", tt.name, got, tt.blob)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package monitor
+
+import (
+	"time"
+)
+
+// exportWindow is how long Data Export appends to the blobs in a folder.
+const exportWindow = 5 * time.Minute
+
+// SettlePolicy decides when Data Export is done appending to a blob, so it is
+// safe to ship and delete it.
+type SettlePolicy struct {
+	// Grace is how long to wait after the end of the blob folder's 5 minute
+	// window, for rows Data Export writes late.
+	Grace time.Duration
+	// Quiet is how long the blob must not have been modified.
+	Quiet time.Duration
+}
+
+var DefaultSettlePolicy = SettlePolicy{
+	Grace: 5 * time.Minute,
+	Quiet: 2 * time.Minute,
+}
+
+// SettledAt returns the time from which the blob is settled. Only append blobs can
+// change after they are written, every other blob type is settled straight away.
+// The blob's properties must be loaded for the quiet period and type to apply.
+func (p SettlePolicy) SettledAt(b *Blob) (time.Time, error) {
+	if b.propsLoaded && !b.appendBlob {
+		return time.Time{}, nil
+	}
+
+	bTime, err := b.Date()
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	settled := bTime.Truncate(time.Minute).Add(exportWindow + p.Grace)
+	if b.propsLoaded {
+		if quiet := b.lastModified.Add(p.Quiet); quiet.After(settled) {
+			settled = quiet
+		}
+	}
+
+	return settled, nil
+}
@@ -0,0 +1,73 @@
+package monitor
+
+import (
+	"testing"
+	"time"
+
+	"github.com/axiomhq/sentinelexport/pkg/source"
+)
+
+func TestSettledAt(t *testing.T) {
+	policy := SettlePolicy{Grace: 5 * time.Minute, Quiet: 2 * time.Minute}
+	window := time.Date(2024, 1, 31, 23, 50, 0, 0, time.UTC)
+	// the window ends at 23:55 and the grace period at 00:00
+	graceEnd := window.Add(10 * time.Minute)
+
+	appended := func(name string, lastModified time.Time) *Blob {
+		return newBlobFromProperties("am-signinlogs", &source.Properties{Name: folder + name, LastModified: lastModified, Size: 1, Append: true})
+	}
+
+	tests := []struct {
+		name string
+		blob *Blob
+		want time.Time
+	}{
+		{"block blob", newBlobFromProperties("am-signinlogs", &source.Properties{Name: folder + "PT05M.json", LastModified: window}), time.Time{}},
+		{"no properties", newBlob("am-signinlogs", folder+"PT05M.json"), graceEnd},
+		{"quiet before the grace period ends", appended("PT05M.json", window.Add(4*time.Minute)), graceEnd},
+		{"modified after the window", appended("PT05M.json", graceEnd.Add(time.Minute)), graceEnd.Add(3 * time.Minute)},
+		{"rolled over blob", appended("PT05M_3.json", window.Add(time.Minute)), graceEnd},
+	}
+
+	for _, tt := range tests {
+		got, err := policy.SettledAt(tt.blob)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("%s: settled at %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestSettledAtGrowingBlob(t *testing.T) {
+	policy := SettlePolicy{Grace: time.Minute, Quiet: 2 * time.Minute}
+	window := time.Date(2024, 1, 31, 23, 50, 0, 0, time.UTC)
+
+	blob := newBlobFromProperties("am-signinlogs", &source.Properties{Name: folder + "PT05M.json", LastModified: window.Add(5 * time.Minute), Size: 10, Append: true})
+	first, err := policy.SettledAt(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := window.Add(7 * time.Minute); !first.Equal(want) {
+		t.Fatalf("settled at %s, want %s", first, want)
+	}
+
+	// appended to again, every append restarts the quiet period
+	blob.setProperties(&source.Properties{Name: folder + "PT05M.json", LastModified: window.Add(9 * time.Minute), Size: 20, Append: true})
+	second, err := policy.SettledAt(blob)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := window.Add(11 * time.Minute); !second.Equal(want) {
+		t.Errorf("settled at %s after growing, want %s", second, want)
+	}
+}
+
+func TestSettledAtInvalidName(t *testing.T) {
+	blob := newBlobFromProperties("am-signinlogs", &source.Properties{Name: "not-data-export.json", Append: true})
+	if _, err := DefaultSettlePolicy.SettledAt(blob); err == nil {
+		t.Error("settled a blob whose name has no time")
+	}
+}
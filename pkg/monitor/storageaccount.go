@@ -2,9 +2,8 @@ package monitor
 
 import (
 	"context"
-	"fmt"
 
-	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/axiomhq/sentinelexport/pkg/source"
 )
 
 type StorageAccountMonitor struct {
@@ -17,21 +16,19 @@ func NewStorageAccountMonitor(storageURL string) *StorageAccountMonitor {
 	}
 }
 
-func (c *StorageAccountMonitor) ListContainers(ctx context.Context, client *azblob.Client) (containers []*ContainerMonitor, err error) {
-	pager := client.NewListContainersPager(&azblob.ListContainersOptions{
-		Prefix: &amPrefix,
-	})
-
-	for pager.More() {
-		page, err := pager.NextPage(ctx)
-		if err != nil {
-			return nil, fmt.Errorf("can not get next page: %w", err)
-		}
+func (c *StorageAccountMonitor) ListContainers(ctx context.Context, src source.Source) (containers []*ContainerMonitor, err error) {
+	names, err := src.ListContainers(ctx, amPrefix)
+	if err != nil {
+		return nil, err
+	}
 
-		for _, item := range page.ContainerItems {
-			containers = append(containers, NewContainerMonitor(c.storageURL, *item.Name))
-		}
+	for _, name := range names {
+		containers = append(containers, NewContainerMonitor(c.storageURL, name))
 	}
 
 	return containers, nil
 }
+
+func (c *StorageAccountMonitor) Container(name string) *ContainerMonitor {
+	return NewContainerMonitor(c.storageURL, name)
+}
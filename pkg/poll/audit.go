@@ -0,0 +1,264 @@
+package poll
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/axiomhq/axiom-go/axiom/ingest"
+	"github.com/axiomhq/sentinelexport/pkg/axm"
+	"github.com/axiomhq/sentinelexport/pkg/metrics"
+	"github.com/axiomhq/sentinelexport/pkg/monitor"
+	"github.com/axiomhq/sentinelexport/pkg/sink"
+	"github.com/axiomhq/sentinelexport/pkg/source"
+)
+
+// what happened to a blob, as recorded in the audit trail
+const (
+	outcomeShipped      = "shipped"
+	outcomeFailed       = "failed"
+	outcomeDeadLettered = "dead-lettered"
+)
+
+// WithAudit writes an event to the dataset for every blob that was shipped,
+// failed to ship or was dead-lettered. The events are chained by their hash, so
+// an event that is missing or was changed can be told. The end of the chain is
+// kept in the checkpoint container, so the next run carries on with it; a nil
+// store starts a new chain every run.
+func WithAudit(client *axm.Client, dataset *axm.Dataset, checkpoints *monitor.CheckpointStore) Option {
+	return func(p *Poll) {
+		p.audit = newAuditor(client, dataset, checkpoints)
+	}
+}
+
+// shipment is what shipping a blob did.
+type shipment struct {
+	offset   int64
+	started  time.Time
+	duration time.Duration
+	status   *ingest.Status
+
+	// what was read from the blob by the last attempt, a retry reads it again
+	counted *countingReader
+}
+
+// done records how shipping the blob ended.
+func (s *shipment) done(status *ingest.Status) {
+	s.duration = time.Since(s.started)
+	s.status = status
+}
+
+// auditEvent is written to the audit dataset for every blob.
+type auditEvent struct {
+	Time     time.Time `json:"_time"`
+	RunID    string    `json:"run_id"`
+	Sequence uint64    `json:"sequence"`
+
+	Container string     `json:"container"`
+	Blob      string     `json:"blob"`
+	BlobTime  *time.Time `json:"blob_time,omitempty"`
+	Table     string     `json:"table"`
+	Dataset   string     `json:"dataset"`
+
+	// Offset is where in the blob shipping started, after what was tailed.
+	Offset int64  `json:"offset,omitempty"`
+	Bytes  int64  `json:"bytes"`
+	SHA256 string `json:"sha256,omitempty"`
+
+	Status   *ingest.Status `json:"status,omitempty"`
+	Duration float64        `json:"duration"`
+
+	Outcome      string `json:"outcome"`
+	Error        string `json:"error,omitempty"`
+	Deleted      bool   `json:"deleted"`
+	Checkpointed bool   `json:"checkpointed"`
+
+	PreviousHash string `json:"previous_hash"`
+	Hash         string `json:"hash"`
+}
+
+// canonical is what the hash of the event covers: the event without its hash,
+// which includes the hash of the previous event, as JSON with sorted keys, no
+// whitespace and no HTML escaping, so it can be reproduced from the event as it
+// was written.
+func (e *auditEvent) canonical() ([]byte, error) {
+	unhashed := *e
+	unhashed.Hash = ""
+	data, err := json.Marshal(&unhashed)
+	if err != nil {
+		return nil, err
+	}
+
+	// maps marshal with sorted keys, numbers are kept as they were written
+	var fields map[string]any
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&fields); err != nil {
+		return nil, err
+	}
+	delete(fields, "hash")
+
+	var out bytes.Buffer
+	enc := json.NewEncoder(&out)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(fields); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(out.Bytes(), []byte("\n")), nil
+}
+
+type auditor struct {
+	client      *axm.Client
+	out         sink.Sink
+	dataset     *axm.Dataset
+	checkpoints *monitor.CheckpointStore
+	runID       string
+
+	// events are written one at a time, so they are in the dataset in the
+	// order of the chain
+	mu       sync.Mutex
+	loaded   bool
+	sequence uint64
+	previous string
+}
+
+func newAuditor(client *axm.Client, dataset *axm.Dataset, checkpoints *monitor.CheckpointStore) *auditor {
+	id := make([]byte, 8)
+	_, _ = rand.Read(id)
+
+	return &auditor{
+		client:      client,
+		out:         sink.NewAxiom(client),
+		dataset:     dataset,
+		checkpoints: checkpoints,
+		runID:       hex.EncodeToString(id),
+	}
+}
+
+// record writes the audit event of the blob. Failing to write it is logged
+// rather than failing the blob, which has been shipped by now.
+func (p *Poll) record(ctx context.Context, blob *monitor.Blob, t *table, shipped *shipment, outcome string, cause error, src source.Source) {
+	if p.audit == nil || p.dryRun || ctx.Err() != nil {
+		return
+	}
+
+	e := &auditEvent{
+		Container: blob.ContainerName(),
+		Blob:      blob.BlobName(),
+		Table:     t.name,
+		Dataset:   t.dataset.NameIn(p.audit.client),
+		Outcome:   outcome,
+	}
+	if date, err := blob.Date(); err == nil {
+		date = date.Truncate(time.Minute)
+		e.BlobTime = &date
+	}
+	if shipped != nil {
+		e.Offset = shipped.offset
+		e.Status = shipped.status
+		e.Duration = shipped.duration.Seconds()
+		if counted := shipped.counted; counted != nil {
+			e.Bytes = counted.n
+			if counted.digest != nil {
+				e.SHA256 = hex.EncodeToString(counted.digest.Sum(nil))
+			}
+		}
+	}
+	if cause != nil {
+		e.Error = cause.Error()
+	}
+	if outcome != outcomeFailed {
+		e.Deleted = !p.retain
+		e.Checkpointed = p.checkpoints != nil
+	}
+
+	if err := p.audit.write(ctx, e, src); err != nil {
+		metrics.AuditFailures.Inc()
+		t.log.Error("can not write audit event", blobAttrs(blob, "error", err)...)
+	}
+}
+
+func (a *auditor) write(ctx context.Context, e *auditEvent, src source.Source) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if !a.loaded {
+		if err := a.load(ctx, src); err != nil {
+			return err
+		}
+	}
+
+	e.Time = time.Now().UTC()
+	e.RunID = a.runID
+	e.Sequence = a.sequence + 1
+	e.PreviousHash = a.previous
+	canonical, err := e.canonical()
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(canonical)
+	e.Hash = hex.EncodeToString(sum[:])
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+
+	if err := a.out.Ensure(ctx, a.dataset); err != nil {
+		return err
+	}
+
+	status, err := a.out.Write(ctx, a.dataset, func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	})
+	if err != nil {
+		return err
+	}
+	if status.Failed > 0 {
+		return fmt.Errorf("audit event was rejected: %s", status.Failures[0].Error)
+	}
+
+	// only move the chain on once the event is in, so the chain has no gaps
+	a.sequence = e.Sequence
+	a.previous = e.Hash
+
+	if a.checkpoints == nil {
+		return nil
+	}
+	// the event is in, so this only means the next run starts from an older
+	// event and forks the chain there
+	err = a.checkpoints.SetAuditChain(ctx, src, a.dataset.Name(), &monitor.AuditChain{
+		RunID:    a.runID,
+		Sequence: a.sequence,
+		Hash:     a.previous,
+	})
+	if err != nil {
+		logger.Error("can not save audit chain", "sequence", a.sequence, "error", err)
+	}
+	return nil
+}
+
+// load carries on with the chain where the previous run left it.
+func (a *auditor) load(ctx context.Context, src source.Source) error {
+	if a.checkpoints != nil {
+		chain, err := a.checkpoints.GetAuditChain(ctx, src, a.dataset.Name())
+		if err != nil {
+			return err
+		}
+		if chain != nil {
+			a.sequence, a.previous = chain.Sequence, chain.Hash
+			logger.Info("carrying on with the audit chain", "previous_run_id", chain.RunID, "sequence", chain.Sequence)
+		}
+	}
+
+	a.loaded = true
+	return nil
+}
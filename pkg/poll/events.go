@@ -0,0 +1,138 @@
+package poll
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/axiomhq/sentinelexport/pkg/monitor"
+)
+
+// how long to wait before asking the queue again when it was empty or errored
+const queueIdleWait = 5 * time.Second
+
+func (s *scheduler) runEvents(ctx context.Context) {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.reconcileLoop(ctx, s.p.reconcileInterval)
+	}()
+
+	for {
+		if ctx.Err() != nil {
+			return
+		}
+
+		events, err := s.p.queue.Receive(ctx)
+		if err != nil && !errors.Is(err, context.Canceled) {
+			logger.Error("can not receive blob events", "error", err)
+		}
+
+		for _, ev := range events {
+			if container := s.sam.Container(ev.Blob.ContainerName()); s.p.enabled(container) {
+				s.loop(ctx, container).add(ev.Blob)
+			}
+			if err := s.p.queue.Ack(ctx, ev); err != nil {
+				logger.Error("can not ack blob event", blobAttrs(ev.Blob, "container", ev.Blob.ContainerName(), "error", err)...)
+			}
+		}
+
+		if len(events) == 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(queueIdleWait):
+			}
+		}
+	}
+}
+
+// reconcileLoop periodically lists every container as a safety net for events
+// that were lost or arrived before we started consuming the queue.
+func (s *scheduler) reconcileLoop(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if err := s.reconcile(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			logger.Error("can not reconcile containers", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *scheduler) reconcile(ctx context.Context) error {
+	containers, err := s.discover(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, container := range containers {
+		started := time.Now()
+		blobs, err := container.ListBlobs(ctx, s.src)
+		if err != nil {
+			return err
+		}
+
+		s.loop(ctx, container).replace(blobs, started)
+	}
+
+	return nil
+}
+
+func (c *containerLoop) add(blob *monitor.Blob) {
+	c.mu.Lock()
+	// an event delivered again, or late, for a blob that was already shipped
+	if _, ok := c.deleted[blob.BlobName()]; ok {
+		c.mu.Unlock()
+		return
+	}
+	if _, ok := c.pending[blob.BlobName()]; !ok {
+		c.pending[blob.BlobName()] = &pendingBlob{blob: blob, added: time.Now()}
+	}
+	c.mu.Unlock()
+
+	c.poke()
+}
+
+// replace makes the listing the source of truth for the container, while keeping
+// blobs from events that arrived after the listing started.
+func (c *containerLoop) replace(blobs []*monitor.Blob, started time.Time) {
+	c.mu.Lock()
+
+	listed := make(map[string]struct{}, len(blobs))
+	for _, blob := range blobs {
+		listed[blob.BlobName()] = struct{}{}
+		if _, ok := c.deleted[blob.BlobName()]; ok {
+			continue
+		}
+		if _, ok := c.pending[blob.BlobName()]; !ok {
+			c.pending[blob.BlobName()] = &pendingBlob{blob: blob, added: started}
+		}
+	}
+
+	for name, pb := range c.pending {
+		if _, ok := listed[name]; !ok && pb.added.Before(started) {
+			delete(c.pending, name)
+		}
+	}
+
+	for name, deletedAt := range c.deleted {
+		if deletedAt.Before(started) {
+			delete(c.deleted, name)
+		}
+	}
+
+	c.mu.Unlock()
+
+	c.poke()
+}
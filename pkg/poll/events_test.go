@@ -0,0 +1,106 @@
+package poll_test
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azqueue"
+	"github.com/axiomhq/sentinelexport/pkg/monitor"
+	"github.com/axiomhq/sentinelexport/pkg/poll"
+)
+
+// eventQueue returns an empty queue in Azurite to send blob events to.
+func eventQueue(t *testing.T, connectionString string) *azqueue.QueueClient {
+	t.Helper()
+
+	ctx := context.Background()
+	queue, err := azqueue.NewQueueClientFromConnectionString(connectionString, "sentinel-sync-events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// it may be left over from an earlier run
+	_, _ = queue.Create(ctx, nil)
+	if _, err := queue.ClearMessages(ctx, nil); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _, _ = queue.Delete(context.Background(), nil) })
+
+	return queue
+}
+
+// blobCreated sends the blob created event of the blob to the queue, base64
+// encoded like Event Grid does.
+func blobCreated(t *testing.T, queue *azqueue.QueueClient, container, name string) {
+	t.Helper()
+
+	event := fmt.Sprintf(`{"eventType":"Microsoft.Storage.BlobCreated","subject":"/blobServices/default/containers/%s/blobs/%s"}`, container, name)
+	if _, err := queue.EnqueueMessage(context.Background(), base64.StdEncoding.EncodeToString([]byte(event)), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// eventually fails the test if done doesn't report true within 30 seconds.
+func eventually(t *testing.T, what string, done func() bool) {
+	t.Helper()
+
+	for deadline := time.Now().Add(30 * time.Second); !done(); time.Sleep(100 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+	}
+}
+
+func TestEventsSkipShippedBlobs(t *testing.T) {
+	connectionString := azurite(t)
+	src := azureSource(t, connectionString)
+	queue := eventQueue(t, connectionString)
+
+	name := blobName(start, 0)
+	seed(t, src, "am-signinlogs", name, rows(t, row(start)))
+	blobCreated(t, queue, "am-signinlogs", name)
+
+	deadLetters := monitor.NewDeadLetterStore("sentinel-sync-deadletter")
+	if err := deadLetters.Ensure(context.Background(), src); err != nil {
+		t.Fatal(err)
+	}
+
+	ax := newFakeAxiom(t)
+	p := poll.NewPoller(2, poll.WithEvents(monitor.NewQueueMonitor(queue), time.Hour), poll.WithDeadLetters(deadLetters, 1))
+	if err := p.Start(context.Background(), src, ax.sink(t, ""), monitor.NewStorageAccountMonitor("")); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = p.Stop() })
+
+	eventually(t, "the blob to be shipped", func() bool {
+		return len(blobNames(t, src, "am-signinlogs")) == 0
+	})
+
+	// delivered again after the blob was shipped and deleted
+	blobCreated(t, queue, "am-signinlogs", name)
+	eventually(t, "the late event to be received", func() bool {
+		peeked, err := queue.PeekMessages(context.Background(), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(peeked.Messages) == 0
+	})
+	// the loop syncs as soon as the event is received
+	time.Sleep(time.Second)
+
+	if err := p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	if got := len(ax.rows("SigninLogs")); got != 1 {
+		t.Errorf("ingested %d rows, want 1", got)
+	}
+	if ts := tableSummary(p.Summary(), "SigninLogs"); ts.Errors != 0 {
+		t.Errorf("the late event failed the blob %d times", ts.Errors)
+	}
+	if left := blobNames(t, src, "sentinel-sync-deadletter"); len(left) != 0 {
+		t.Errorf("dead-lettered %q", left)
+	}
+}
@@ -0,0 +1,215 @@
+package poll
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/axiomhq/axiom-go/axiom/ingest"
+	"github.com/axiomhq/sentinelexport/pkg/axm"
+	"github.com/axiomhq/sentinelexport/pkg/monitor"
+	"github.com/axiomhq/sentinelexport/pkg/source"
+	"github.com/axiomhq/sentinelexport/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// FailurePolicy decides what happens to a blob when axiom rejects some of its rows.
+type FailurePolicy string
+
+const (
+	// FailureKeep leaves the blob in place and stops the container, so the blob
+	// is retried on the next cycle. The rows that were ingested are sent again.
+	FailureKeep FailurePolicy = "keep"
+	// FailureDeadLetter copies the rejected rows to the dead-letter container and
+	// carries on with the blob as if it succeeded.
+	FailureDeadLetter FailurePolicy = "deadletter"
+	// FailureDrop accepts the loss of the rejected rows.
+	FailureDrop FailurePolicy = "drop"
+)
+
+func ParseFailurePolicy(s string) (FailurePolicy, error) {
+	switch policy := FailurePolicy(s); policy {
+	case FailureKeep, FailureDeadLetter, FailureDrop:
+		return policy, nil
+	}
+
+	return "", fmt.Errorf("unknown failure policy %q, must be one of %q, %q or %q", s, FailureKeep, FailureDeadLetter, FailureDrop)
+}
+
+// errFailedRows stops a container when rows failed to ingest and the failure
+// policy is to keep the blob.
+var errFailedRows = errors.New("rows failed to ingest")
+
+// handleFailures applies the failure policy to the rows that failed to ingest,
+// rows opens the data that was ingested again.
+func (p *Poll) handleFailures(ctx context.Context, blob *monitor.Blob, t *table, offset int64, status *ingest.Status,
+	rows func() (io.ReadCloser, error), src source.Source) error {
+	if status.Failed == 0 || p.dryRun {
+		return nil
+	}
+
+	switch p.failurePolicy {
+	case FailureDrop:
+		t.log.Warn("dropping failed rows", blobAttrs(blob, "rows_failed", status.Failed)...)
+		return nil
+
+	case FailureDeadLetter:
+		r, err := rows()
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return fmt.Errorf("can not read blob %q: %w", blob.BlobName(), err)
+		}
+
+		failed, matched := failedRows(data, t.dataset.TimestampFieldName(), t.timestampLayout(), status.Failures)
+		if uint64(matched) < status.Failed {
+			// can't tell every failed row apart, so keep all of them rather than
+			// lose some; this means rows that were ingested are in there too
+			failed = data
+		}
+
+		if err := p.deadLetters.PutRows(ctx, src, blob, offset, failed, status); err != nil {
+			return err
+		}
+
+		t.log.Warn("dead-lettered failed rows", blobAttrs(blob, "rows_failed", status.Failed)...)
+		return nil
+	}
+
+	var firstError string
+	if len(status.Failures) > 0 {
+		firstError = status.Failures[0].Error
+	}
+	return fmt.Errorf("%w: failed=%d, first error: %s", errFailedRows, status.Failed, firstError)
+}
+
+// failedRows picks the rows whose timestamp matches one of the failures, as that
+// is all axiom reports about the events it rejected.
+func failedRows(data []byte, timestampField, layout string, failures []*ingest.Failure) ([]byte, int) {
+	remaining := make(map[int64]int, len(failures))
+	for _, f := range failures {
+		remaining[f.Timestamp.UnixNano()]++
+	}
+
+	var (
+		out     bytes.Buffer
+		matched int
+	)
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+
+		var row map[string]json.RawMessage
+		if err := json.Unmarshal(line, &row); err != nil {
+			continue
+		}
+
+		var value string
+		if err := json.Unmarshal(row[timestampField], &value); err != nil {
+			continue
+		}
+
+		ts, err := time.Parse(layout, value)
+		if err != nil {
+			continue
+		}
+
+		if remaining[ts.UnixNano()] > 0 {
+			remaining[ts.UnixNano()]--
+			out.Write(line)
+			out.WriteByte('\n')
+			matched++
+		}
+	}
+
+	return out.Bytes(), matched
+}
+
+// blobFailed records a failed attempt at shipping the blob. Once the blob has
+// failed maxAttempts times, or it can never be shipped, it is moved to the
+// dead-letter store and completed so the rest of its container can carry on;
+// next reports whether that happened. Only failures caused by the blob itself
+// count, a sink or source that is unavailable is retried for as long as it takes.
+// ahead is passed on to completeBlob.
+func (p *Poll) blobFailed(ctx context.Context, blob *monitor.Blob, t *table, cp *monitor.Checkpoint, cause error, src source.Source, ahead bool) (_ *monitor.Checkpoint, next bool) {
+	if ctx.Err() != nil || p.dryRun || p.deadLetters == nil || p.maxAttempts <= 0 || !blobsFault(cause) {
+		return cp, false
+	}
+
+	attempts := p.attempt(blob)
+	if attempts < p.maxAttempts && !errors.Is(cause, monitor.ErrInvalidBlobName) {
+		return cp, false
+	}
+
+	deadLetterCtx, span := tracer.Start(ctx, "blob.deadletter", trace.WithAttributes(attribute.Int("attempts", attempts)))
+	err := p.deadLetters.PutBlob(deadLetterCtx, src, blob, cause, attempts)
+	tracing.End(span, err)
+	if err != nil {
+		t.log.Error("can not dead-letter blob", blobAttrs(blob, "error", err)...)
+		return cp, false
+	}
+	t.log.Warn("dead-lettered blob", blobAttrs(blob, "attempts", attempts, "error", cause)...)
+
+	cp, err = p.completeBlob(ctx, blob, cp, src, ahead)
+	if err != nil {
+		t.log.Error("can not complete blob", blobAttrs(blob, "error", err)...)
+		return cp, false
+	}
+
+	return cp, true
+}
+
+// tailFailed stops tailing a blob that failed because of the blob itself, as
+// tailing it again would send the rows that were ingested every time. The blob
+// waits until it settles and is shipped from where tailing got to, so it is
+// retried and dead-lettered like any other blob; the failure counts as one of
+// its attempts.
+func (p *Poll) tailFailed(ctx context.Context, blob *monitor.Blob, t *table, cp *monitor.Checkpoint, cause error, src source.Source) *monitor.Checkpoint {
+	if ctx.Err() != nil || !blobsFault(cause) {
+		return cp
+	}
+
+	attempts := p.attempt(blob)
+	next, err := p.checkpoints.FailPartial(ctx, src, cp, blob)
+	if err != nil {
+		t.log.Error("can not stop tailing blob", blobAttrs(blob, "error", err)...)
+		return cp
+	}
+	t.log.Warn("stopped tailing blob until it settles", blobAttrs(blob, "attempts", attempts, "error", cause)...)
+
+	return next
+}
+
+// blobsFault reports whether the blob itself is why it failed to ship: its name
+// is invalid, or axiom rejected its rows or couldn't parse them.
+func blobsFault(err error) bool {
+	return errors.Is(err, monitor.ErrInvalidBlobName) || errors.Is(err, errFailedRows) || axm.Rejected(err)
+}
+
+func (p *Poll) attempt(blob *monitor.Blob) int {
+	p.attemptsMu.Lock()
+	defer p.attemptsMu.Unlock()
+
+	key := blob.ContainerName() + "/" + blob.BlobName()
+	p.attempts[key]++
+	return p.attempts[key]
+}
+
+func (p *Poll) forgetAttempts(blob *monitor.Blob) {
+	p.attemptsMu.Lock()
+	defer p.attemptsMu.Unlock()
+
+	delete(p.attempts, blob.ContainerName()+"/"+blob.BlobName())
+}
@@ -0,0 +1,459 @@
+package poll_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/axiomhq/axiom-go/axiom"
+	"github.com/axiomhq/axiom-go/axiom/ingest"
+	"github.com/axiomhq/sentinelexport/pkg/axm"
+	"github.com/axiomhq/sentinelexport/pkg/monitor"
+	"github.com/axiomhq/sentinelexport/pkg/poll"
+	"github.com/axiomhq/sentinelexport/pkg/sink"
+	"github.com/axiomhq/sentinelexport/pkg/source"
+	collectortrace "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+// azuriteEnv holds the connection string of an Azurite to run the tests against
+// as well as a directory. Every container in it is deleted.
+const azuriteEnv = "AZURITE_CONNECTION_STRING"
+
+// workspace is the prefix Data Export puts in front of every blob name.
+const workspace = "WorkspaceResourceId=/subscriptions/00000000-0000-0000-0000-000000000000/resourcegroups/rg/providers/microsoft.operationalinsights/workspaces/ws"
+
+// blobName returns the name Data Export gives the blob for the 5 minute window
+// starting at t, count is the number of the blob in the window after the first.
+func blobName(t time.Time, count int) string {
+	name := "PT05M.json"
+	if count > 0 {
+		name = fmt.Sprintf("PT05M_%d.json", count)
+	}
+	return fmt.Sprintf("%s/y=%04d/m=%02d/d=%02d/h=%02d/m=%02d/%s", workspace, t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), name)
+}
+
+// rows returns newline delimited rows the way Data Export writes them.
+func rows(t *testing.T, rows ...map[string]any) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	for _, row := range rows {
+		line, err := json.Marshal(row)
+		if err != nil {
+			t.Fatal(err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	return buf.Bytes()
+}
+
+func row(ts time.Time, fields ...any) map[string]any {
+	r := map[string]any{"TimeGenerated": ts.Format(time.RFC3339Nano)}
+	for i := 0; i+1 < len(fields); i += 2 {
+		r[fields[i].(string)] = fields[i+1]
+	}
+	return r
+}
+
+// sources runs the test against every source available: always a directory,
+// and Azurite when azuriteEnv is set.
+func sources(t *testing.T, test func(t *testing.T, src source.Source)) {
+	t.Run("dir", func(t *testing.T) {
+		test(t, source.NewDir(t.TempDir()))
+	})
+
+	t.Run("azure", func(t *testing.T) {
+		test(t, azureSource(t, azurite(t)))
+	})
+}
+
+// azurite returns the connection string of the Azurite to test against, and
+// skips the test when there is none.
+func azurite(t *testing.T) string {
+	t.Helper()
+
+	connectionString := os.Getenv(azuriteEnv)
+	if connectionString == "" {
+		t.Skipf("%s is not set", azuriteEnv)
+	}
+	return connectionString
+}
+
+// azureSource returns a source reading the Azurite storage account, which is
+// emptied before and after the test.
+func azureSource(t *testing.T, connectionString string) source.Source {
+	t.Helper()
+
+	client, err := azblob.NewClientFromConnectionString(connectionString, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	emptyStorageAccount(t, client)
+	t.Cleanup(func() { emptyStorageAccount(t, client) })
+
+	return source.NewAzure(client)
+}
+
+// emptyStorageAccount deletes every container, so tests don't see each other's
+// blobs.
+func emptyStorageAccount(t *testing.T, client *azblob.Client) {
+	t.Helper()
+
+	ctx := context.Background()
+	pager := client.NewListContainersPager(nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, item := range page.ContainerItems {
+			if _, err := client.DeleteContainer(ctx, *item.Name, nil); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// seed uploads the blob to the container, creating the container if needed.
+func seed(t *testing.T, src source.Source, container, name string, data []byte) {
+	t.Helper()
+
+	ctx := context.Background()
+	if err := src.CreateContainer(ctx, container); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Upload(ctx, container, name, bytes.NewReader(data), nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// blobNames lists the names of the blobs left in the container.
+func blobNames(t *testing.T, src source.Source, container string) []string {
+	t.Helper()
+
+	blobs, err := src.ListBlobs(context.Background(), container)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := make([]string, 0, len(blobs))
+	for _, blob := range blobs {
+		names = append(names, blob.Name)
+	}
+	return names
+}
+
+// readBlob returns the content of the blob.
+func readBlob(t *testing.T, src source.Source, container, name string) []byte {
+	t.Helper()
+
+	r, err := src.Open(context.Background(), container, name, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// fakeAxiom is the part of the axiom API the exporter uses: listing and creating
+// datasets, and ingesting into them.
+type fakeAxiom struct {
+	*httptest.Server
+
+	// reject returns why axiom rejects the row, or "" to ingest it.
+	reject func(dataset string, row map[string]any) string
+	// status, when not 0, is returned by every ingest instead of ingesting,
+	// with retryAfter as its Retry-After header if set.
+	status     int
+	retryAfter string
+	// retries is how many times the sink tries an ingest, once when 0.
+	retries int
+	// apiToken makes the sink use an API token instead of a personal token, and
+	// noCreate refuses to create datasets as a token without the permission.
+	apiToken bool
+	noCreate bool
+
+	mu       sync.Mutex
+	datasets []string
+	created  []string
+	ingested map[string][]map[string]any
+	ingests  int
+}
+
+func newFakeAxiom(t *testing.T, datasets ...string) *fakeAxiom {
+	f := &fakeAxiom{
+		datasets: datasets,
+		ingested: map[string][]map[string]any{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/datasets", f.handleDatasets)
+	mux.HandleFunc("/v1/datasets/", f.handleIngest)
+
+	f.Server = httptest.NewServer(mux)
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+func (f *fakeAxiom) handleDatasets(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch r.Method {
+	case http.MethodGet:
+		list := make([]axiom.Dataset, 0, len(f.datasets))
+		for _, name := range f.datasets {
+			list = append(list, axiom.Dataset{ID: name, Name: name})
+		}
+		writeJSON(w, http.StatusOK, list)
+
+	case http.MethodPost:
+		if f.noCreate {
+			writeJSON(w, http.StatusForbidden, map[string]string{"message": "forbidden"})
+			return
+		}
+
+		var req axiom.DatasetCreateRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, name := range f.datasets {
+			if name == req.Name {
+				writeJSON(w, http.StatusConflict, map[string]string{"message": "dataset exists"})
+				return
+			}
+		}
+		f.datasets = append(f.datasets, req.Name)
+		f.created = append(f.created, req.Name)
+		writeJSON(w, http.StatusOK, axiom.Dataset{ID: req.Name, Name: req.Name})
+
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (f *fakeAxiom) handleIngest(w http.ResponseWriter, r *http.Request) {
+	dataset, ok := strings.CutSuffix(strings.TrimPrefix(r.URL.Path, "/v1/datasets/"), "/ingest")
+	if !ok || r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.ingests++
+	if !slices.Contains(f.datasets, dataset) {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "dataset not found"})
+		return
+	}
+	if f.status != 0 {
+		if f.retryAfter != "" {
+			w.Header().Set("Retry-After", f.retryAfter)
+		}
+		writeJSON(w, f.status, map[string]string{"message": http.StatusText(f.status)})
+		return
+	}
+
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+
+	data, err := io.ReadAll(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status := ingest.Status{ProcessedBytes: uint64(len(data))}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		var row map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if f.reject != nil {
+			if cause := f.reject(dataset, row); cause != "" {
+				ts, _ := time.Parse(time.RFC3339Nano, fmt.Sprint(row[r.URL.Query().Get("timestamp-field")]))
+				status.Failed++
+				status.Failures = append(status.Failures, &ingest.Failure{Timestamp: ts, Error: cause})
+				continue
+			}
+		}
+
+		status.Ingested++
+		f.ingested[dataset] = append(f.ingested[dataset], row)
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// rows returns the rows ingested into the dataset, in the order they were ingested.
+func (f *fakeAxiom) rows(dataset string) []map[string]any {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.ingested[dataset]
+}
+
+func (f *fakeAxiom) createdDatasets() []string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return f.created
+}
+
+// sink returns an axiom sink ingesting into the fake.
+func (f *fakeAxiom) sink(t *testing.T, prefix string) sink.Sink {
+	t.Helper()
+
+	return sink.NewAxiom(f.client(t, prefix))
+}
+
+// client returns a client of the fake with a personal token, so datasets are
+// listed and created, or with an API token if apiToken is set.
+func (f *fakeAxiom) client(t *testing.T, prefix string) *axm.Client {
+	t.Helper()
+
+	token := axiom.SetPersonalTokenConfig("xapt-00000000-0000-0000-0000-000000000000", "org")
+	if f.apiToken {
+		token = axiom.SetAPITokenConfig("xaat-00000000-0000-0000-0000-000000000000")
+	}
+
+	client, err := axiom.NewClient(
+		axiom.SetNoEnv(),
+		axiom.SetNoRetry(),
+		axiom.SetNoTracing(),
+		axiom.SetURL(f.URL),
+		token,
+		axiom.SetClient(&http.Client{Transport: axm.Transport(http.DefaultTransport)}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &axm.Client{
+		Client:        client,
+		DatasetPrefix: prefix,
+		APIToken:      f.apiToken,
+		Retry: axm.RetryPolicy{
+			MaxAttempts:     max(f.retries, 1),
+			InitialInterval: time.Millisecond,
+			MaxInterval:     time.Millisecond,
+		},
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// drain exports every blob of the source once into out.
+func drain(t *testing.T, src source.Source, out sink.Sink, options ...poll.Option) *poll.Summary {
+	t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	p := poll.NewPoller(4, options...)
+	if err := p.Drain(ctx, src, out, monitor.NewStorageAccountMonitor("")); err != nil {
+		t.Fatal(err)
+	}
+	return p.Summary()
+}
+
+func tableSummary(summary *poll.Summary, table string) poll.TableSummary {
+	for _, ts := range summary.Tables() {
+		if ts.Table == table {
+			return ts
+		}
+	}
+	return poll.TableSummary{Table: table}
+}
+
+// fakeCollector is an OTLP/HTTP collector that keeps the spans sent to it.
+type fakeCollector struct {
+	*httptest.Server
+
+	mu    sync.Mutex
+	spans []*tracepb.Span
+}
+
+func newFakeCollector(t *testing.T) *fakeCollector {
+	f := &fakeCollector{}
+
+	f.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var req collectortrace.ExportTraceServiceRequest
+		if err := proto.Unmarshal(data, &req); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		f.mu.Lock()
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				f.spans = append(f.spans, ss.Spans...)
+			}
+		}
+		f.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		out, _ := proto.Marshal(&collectortrace.ExportTraceServiceResponse{})
+		_, _ = w.Write(out)
+	}))
+	t.Cleanup(f.Close)
+
+	return f
+}
+
+// received returns the spans sent to the collector so far.
+func (f *fakeCollector) received() []*tracepb.Span {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return slices.Clone(f.spans)
+}
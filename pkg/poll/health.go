@@ -0,0 +1,93 @@
+package poll
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// health keeps track of whether the poller is making progress.
+type health struct {
+	mu      sync.Mutex
+	started time.Time
+
+	// the last successful listing of the containers, and the error of the last
+	// listing if it failed
+	listed  time.Time
+	listErr error
+
+	// the last ingest that succeeded, and the last one that failed
+	ingested     time.Time
+	ingestFailed time.Time
+}
+
+func (h *health) listing(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.listErr = err
+	if err == nil {
+		h.listed = time.Now()
+	}
+}
+
+func (h *health) ingest(err error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if err == nil {
+		h.ingested = time.Now()
+	} else {
+		h.ingestFailed = time.Now()
+	}
+}
+
+// Live returns why the poller is stuck: no listing of the containers succeeded
+// within window, or every ingest within window failed. A window of 0 is 10 poll
+// intervals, or 2 reconcile intervals with events if that is longer, as that
+// is how often containers are listed.
+func (p *Poll) Live(window time.Duration) error {
+	if window <= 0 {
+		window = 10 * pollInterval
+		if p.queue != nil {
+			window = max(window, 2*p.reconcileInterval)
+		}
+	}
+
+	h := &p.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	since := time.Now().Add(-window)
+
+	if h.listed.Before(since) && h.started.Before(since) {
+		if h.listed.IsZero() {
+			return fmt.Errorf("no listing of the containers succeeded since starting %s ago", time.Since(h.started).Truncate(time.Second))
+		}
+		return fmt.Errorf("no listing of the containers succeeded for %s", time.Since(h.listed).Truncate(time.Second))
+	}
+
+	if h.ingestFailed.After(since) && h.ingested.Before(since) {
+		return fmt.Errorf("every ingest in the last %s failed", window)
+	}
+
+	return nil
+}
+
+// Ready returns why the poller isn't ready: it didn't list the containers yet,
+// or the last listing failed.
+func (p *Poll) Ready() error {
+	h := &p.health
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.listErr != nil {
+		return fmt.Errorf("last listing of the containers failed: %w", h.listErr)
+	}
+	if h.listed.IsZero() {
+		return errors.New("containers weren't listed yet")
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package poll
+
+import (
+	"time"
+
+	"github.com/axiomhq/sentinelexport/pkg/metrics"
+	"github.com/axiomhq/sentinelexport/pkg/monitor"
+)
+
+// WithStaleAfter flags a table as stale once its container has had no new blob
+// for the period, e.g. because its Data Export rule was removed or the table
+// stopped flowing. A period of 0 never flags tables.
+func WithStaleAfter(period time.Duration) Option {
+	return func(p *Poll) {
+		p.staleAfter = period
+	}
+}
+
+// seen records the newest of the blobs in the container.
+func (c *containerLoop) seen(blobs []*monitor.Blob) {
+	for _, blob := range blobs {
+		if date, err := blob.Date(); err == nil && date.After(c.newest) {
+			c.newest = date
+		}
+	}
+}
+
+// checkStale flags the table as stale when no new blob has been seen for the
+// stale period, going by the time in the name of the newest blob, or since the
+// loop started when it hasn't seen any.
+func (c *containerLoop) checkStale() {
+	period := c.s.p.staleAfter
+	if period <= 0 {
+		return
+	}
+
+	last := c.newest
+	if last.IsZero() {
+		last = c.started
+	}
+	stale := time.Since(last) > period
+
+	attrs := []any{"stale_after", period}
+	if !c.newest.IsZero() {
+		attrs = append(attrs, "last_blob_time", c.newest)
+	}
+	switch {
+	case stale && !c.stale:
+		c.table.log.Warn("table is stale, no new blobs", attrs...)
+	case !stale && c.stale:
+		c.table.log.Info("table is no longer stale", attrs...)
+	}
+	c.stale = stale
+
+	var value float64
+	if stale {
+		value = 1
+	}
+	metrics.TableStale.WithLabelValues(c.table.name).Set(value)
+}
+
+// ingested records that rows of the blob were ingested, for the lag of the table.
+func (t *table) ingested(blob *monitor.Blob) {
+	date, err := blob.Date()
+	if err != nil {
+		date = time.Time{}
+	}
+	metrics.SetIngested(t.name, date)
+}
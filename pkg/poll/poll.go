@@ -1,42 +1,152 @@
 package poll
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
 	"errors"
-	"log"
-	"math/rand"
-	"os"
+	"fmt"
+	"hash"
+	"io"
+	"sync"
 	"time"
 
-	"github.com/alitto/pond"
-
-	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
-	"github.com/axiomhq/sentinelexport/pkg/axm"
+	"github.com/axiomhq/axiom-go/axiom/ingest"
+	"github.com/axiomhq/sentinelexport/pkg/config"
+	"github.com/axiomhq/sentinelexport/pkg/logging"
+	"github.com/axiomhq/sentinelexport/pkg/metrics"
 	"github.com/axiomhq/sentinelexport/pkg/monitor"
+	"github.com/axiomhq/sentinelexport/pkg/sink"
+	"github.com/axiomhq/sentinelexport/pkg/source"
+	"github.com/axiomhq/sentinelexport/pkg/tracing"
+	"github.com/axiomhq/sentinelexport/pkg/transform"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var logger = log.New(os.Stdout, "poll: ", log.LstdFlags)
+var (
+	logger = logging.New("poll")
+	tracer = tracing.New("poll")
+)
 
-// this needs to be a worker pool of containers that streams the blobs oldest to newest
-// and then exists. longer worker pools that might even take hours in backfill mode
-// so be careful around that. but have to syncronously process all the blobs to avoid
-// queuing up all the blobs at once
+// how often containers are listed, and unsettled blobs tailed
+const pollInterval = 30 * time.Second
 
 type Poll struct {
 	wpsize int
 
+	queue             *monitor.QueueMonitor
+	reconcileInterval time.Duration
+
+	checkpoints *monitor.CheckpointStore
+	retain      bool
+	tail        bool
+	settle      monitor.SettlePolicy
+
+	failurePolicy FailurePolicy
+	deadLetters   *monitor.DeadLetterStore
+	maxAttempts   int
+
+	tables map[string]config.Table
+	filter *monitor.TableFilter
+	dryRun bool
+
+	staleAfter time.Duration
+
+	attemptsMu sync.Mutex
+	attempts   map[string]int
+
+	summary *Summary
+	health  health
+	audit   *auditor
+
 	cancel  context.CancelFunc
 	stopped <-chan struct{}
 }
 
-func NewPoller(workerPoolSize int) *Poll {
-	return &Poll{
-		wpsize: workerPoolSize,
+type Option func(p *Poll)
+
+// WithEvents discovers blobs from the blob created events on the queue instead of
+// listing every container on a timer. A full listing still runs every
+// reconcileInterval to pick up anything the events missed.
+func WithEvents(queue *monitor.QueueMonitor, reconcileInterval time.Duration) Option {
+	return func(p *Poll) {
+		p.queue = queue
+		p.reconcileInterval = reconcileInterval
+	}
+}
+
+// WithCheckpoints keeps blobs after they are shipped, tracking progress per
+// container in the checkpoint store instead of deleting them.
+func WithCheckpoints(checkpoints *monitor.CheckpointStore) Option {
+	return func(p *Poll) {
+		p.checkpoints = checkpoints
+		p.retain = true
+	}
+}
+
+// WithTailing ships the lines appended to a blob while it is still being appended
+// to, rather than waiting for it to settle. How far each blob has been shipped is
+// tracked in the checkpoint store.
+func WithTailing(checkpoints *monitor.CheckpointStore) Option {
+	return func(p *Poll) {
+		p.checkpoints = checkpoints
+		p.tail = true
+	}
+}
+
+// WithSettlePolicy overrides monitor.DefaultSettlePolicy, which decides when a
+// blob is no longer being appended to.
+func WithSettlePolicy(settle monitor.SettlePolicy) Option {
+	return func(p *Poll) {
+		p.settle = settle
+	}
+}
+
+// WithFailurePolicy sets what happens to blobs when rows fail to ingest, the
+// default is FailureKeep. FailureDeadLetter requires WithDeadLetters.
+func WithFailurePolicy(policy FailurePolicy) Option {
+	return func(p *Poll) {
+		p.failurePolicy = policy
+	}
+}
+
+// WithDeadLetters moves blobs that failed to ship maxAttempts times, or that can
+// never be shipped, to the dead-letter store so they stop blocking their
+// container. A maxAttempts of 0 only dead-letters rejected rows.
+func WithDeadLetters(deadLetters *monitor.DeadLetterStore, maxAttempts int) Option {
+	return func(p *Poll) {
+		p.deadLetters = deadLetters
+		p.maxAttempts = maxAttempts
+	}
+}
+
+// WithDryRun leaves the storage account as it is: blobs aren't deleted or
+// tailed, and checkpoints and dead-letters aren't written. Rows are still sent
+// to the sink, which should be a sink.DryRun.
+func WithDryRun() Option {
+	return func(p *Poll) {
+		p.dryRun = true
 	}
 }
 
+func NewPoller(workerPoolSize int, options ...Option) *Poll {
+	p := &Poll{
+		wpsize:        workerPoolSize,
+		settle:        monitor.DefaultSettlePolicy,
+		failurePolicy: FailureKeep,
+		attempts:      map[string]int{},
+		summary:       newSummary(),
+		health:        health{started: time.Now()},
+	}
+	for _, option := range options {
+		option(p)
+	}
+	return p
+}
+
 func (p *Poll) Start(ctx context.Context,
-	azClient *azblob.Client, axClient *axm.Client,
+	src source.Source, out sink.Sink,
 	sam *monitor.StorageAccountMonitor,
 ) error {
 	if p.cancel != nil {
@@ -50,20 +160,32 @@ func (p *Poll) Start(ctx context.Context,
 	go func() {
 		defer close(stopped)
 
-		for {
-			if ctx.Err() != nil {
-				return
-			}
-
-			err := p.loop(ctx, azClient, axClient, sam)
-			if err != nil && !errors.Is(err, context.Canceled) {
-				logger.Printf("error in poll loop: %v\n", err)
-			}
-		}
+		newScheduler(p, src, out, sam).run(ctx)
 	}()
 	return nil
 }
 
+// Drain exports every blob that has settled once, rather than running until
+// stopped, Summary then returns what was exported. Blob events are ignored,
+// every container is listed instead.
+func (p *Poll) Drain(ctx context.Context,
+	src source.Source, out sink.Sink,
+	sam *monitor.StorageAccountMonitor,
+) error {
+	if p.cancel != nil {
+		return errors.New("already started")
+	}
+
+	s := newScheduler(p, src, out, sam)
+	s.once = true
+	return s.drain(ctx)
+}
+
+// Summary returns what has been exported so far, or in total once Drain returns.
+func (p *Poll) Summary() *Summary {
+	return p.summary
+}
+
 func (p *Poll) Stop() error {
 	if p.cancel == nil {
 		return errors.New("not started")
@@ -74,110 +196,269 @@ func (p *Poll) Stop() error {
 	return nil
 }
 
-func (p *Poll) loop(ctx context.Context,
-	azClient *azblob.Client, axClient *axm.Client,
-	sam *monitor.StorageAccountMonitor) error {
-
-	ticker := time.NewTicker(30 * time.Second)
-	defer ticker.Stop()
-
-	// TODO: this logic will page over all the containers, create jobs to sync each one
-	// then wait for all the jobs to finish before paging again.
-	// This means that if it takes an hour for one container to sync, we won't pick up new
-	// blobs for other containers until it's done syncing
-	// This is really only an issue if we are backfilling large amounts of data
-	// But we should tackle it.
-	for {
-		if ctx.Err() != nil {
-			return ctx.Err()
-		}
+// shipBlob ships the blob from offset onwards and applies the failure policy to
+// any rows that failed to ingest. offset is non zero when the start of the blob
+// was already shipped while tailing it. What was shipped is returned even when
+// shipping failed, for the audit trail.
+func (p *Poll) shipBlob(ctx context.Context, blob *monitor.Blob, t *table, offset int64, src source.Source, out sink.Sink) (*shipment, error) {
+	shipped := &shipment{offset: offset, started: time.Now()}
+
+	// tailing shipped the blob to its end before it settled, there is nothing
+	// left to download and asking for the range past the end fails
+	if offset > 0 && offset >= blob.Size() {
+		shipped.done(&ingest.Status{})
+		t.log.Debug("blob was tailed to its end", blobAttrs(blob, "offset", offset)...)
+		return shipped, nil
+	}
 
-		containers, err := sam.ListContainers(ctx, azClient)
+	// a retry needs the blob from the start again, so download it again
+	open := func() (io.ReadCloser, error) {
+		openCtx, span := tracer.Start(ctx, "blob.open", trace.WithAttributes(attribute.Int64("offset", offset)))
+		r, err := blob.StreamFrom(openCtx, src, offset)
+		tracing.End(span, err)
 		if err != nil {
-			return err
+			return nil, goneErr(err)
+		}
+		counted := &countingReader{ReadCloser: r, table: t.name}
+		if p.audit != nil {
+			counted.digest = sha256.New()
 		}
+		shipped.counted = counted
+		return transform.NewReader(counted, t.transforms), nil
+	}
 
-		// to avoid prioritizing containers, shuffle
-		rand.Shuffle(len(containers), func(i, j int) {
-			containers[i], containers[j] = containers[j], containers[i]
-		})
+	status, err := p.streamBlob(ctx, blob, t, open, out)
+	shipped.done(status)
+	if counted := shipped.counted; counted != nil {
+		// the ingest streams the blob as it downloads, so this is how much of
+		// it was spent on the download
+		trace.SpanFromContext(ctx).SetAttributes(
+			attribute.Int64("bytes_downloaded", counted.n),
+			attribute.Float64("download_seconds", counted.waited.Seconds()),
+		)
+	}
+	if err != nil {
+		return shipped, err
+	}
+	p.summary.ingested(t.name, status)
 
-		wp := pond.New(p.wpsize, p.wpsize*2)
-		for _, container := range containers {
-			streamContainer(ctx, wp, azClient, axClient, container)
-		}
+	return shipped, p.handleFailures(ctx, blob, t, offset, status, open, src)
+}
 
-		// cancelling ctx should cancel the wp jobs causing them to end early
-		wp.StopAndWait()
+func (p *Poll) streamBlob(ctx context.Context, blob *monitor.Blob, t *table, open func() (io.ReadCloser, error), out sink.Sink) (status *ingest.Status, err error) {
+	ctx, span := tracer.Start(ctx, "blob.ingest")
+	defer func() { tracing.End(span, err) }()
 
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		case <-ticker.C:
-		}
+	if err := out.Ensure(ctx, t.dataset); err != nil {
+		return nil, err
 	}
-}
 
-func streamContainer(ctx context.Context, wp *pond.WorkerPool,
-	azClient *azblob.Client, axClient *axm.Client,
-	container *monitor.ContainerMonitor) {
-	logger.Printf("syncing container=%q, table=%q to axiom\n", container.ContainerName(), container.TableName())
-	wp.Submit(func() {
-		for {
-			if err := ctx.Err(); err != nil {
-				if errors.Is(err, context.Canceled) {
-					return
-				}
-				panic(err)
-			}
-
-			blob, more, err := container.GetNextBlob(ctx, azClient)
-			if err != nil {
-				logger.Printf("can not get next blob, container=%q: %s\n", container.ContainerName(), err)
-				return
-			}
-			if blob == nil {
-				return
-			}
-
-			dataset := container.TableName()
-			if err := streamBlob(ctx, blob, dataset, azClient, axClient); err != nil {
-				logger.Printf("error streaming container=%q, blob=%q: %s\n", blob.ContainerName(), blob.BlobName(), err)
-				return
-			}
-
-			if !more {
-				return
-			}
-		}
-	})
+	started := time.Now()
+	status, err = out.Write(ctx, t.dataset, open)
+	duration := time.Since(started)
+	metrics.IngestDuration.WithLabelValues(t.name).Observe(duration.Seconds())
+	if ctx.Err() == nil {
+		p.health.ingest(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	span.SetAttributes(statusAttrs(status)...)
+	t.ingested(blob)
+
+	t.log.Info("shipped blob", blobAttrs(blob,
+		"bytes", status.ProcessedBytes,
+		"rows_ingested", status.Ingested,
+		"rows_failed", status.Failed,
+		"duration", duration,
+		"lag", blobLag(blob),
+	)...)
+
+	return status, nil
 }
 
-func streamBlob(ctx context.Context, blob *monitor.Blob, datasetName string, azClient *azblob.Client, axClient *axm.Client) error {
-	blobStream, err := blob.Stream(ctx, azClient)
+// tailBlob ships the complete lines appended to a blob since it was last tailed,
+// and records how far it got in the checkpoint.
+func (p *Poll) tailBlob(ctx context.Context, blob *monitor.Blob, t *table, src source.Source, out sink.Sink, cp *monitor.Checkpoint) (_ *monitor.Checkpoint, err error) {
+	offset := cp.Offset(blob)
+	if blob.Size() <= offset || cp.TailFailed(blob) {
+		return cp, nil
+	}
+
+	ctx, span := tracer.Start(ctx, "blob.tail", trace.WithAttributes(blobSpanAttrs(blob, t, attribute.Int64("offset", offset))...))
+	defer func() { tracing.End(span, err) }()
+
+	blobStream, err := blob.StreamFrom(ctx, src, offset)
 	if err != nil {
-		return err
+		return cp, err
 	}
 	defer blobStream.Close()
 
-	ds := axm.NewDataset(datasetName)
-	if err := ds.Ensure(ctx, axClient); err != nil {
-		return err
+	data, err := io.ReadAll(&countingReader{ReadCloser: blobStream, table: t.name})
+	if err != nil {
+		return cp, fmt.Errorf("can not read blob %q: %w", blob.BlobName(), err)
 	}
 
-	// TODO: would be useful to track status
-	status, err := ds.Stream(ctx, axClient, blobStream)
+	// the last line may still be being written
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		return cp, nil
+	}
+	data = data[:end+1]
+	next := offset + int64(len(data))
+
+	data, err = transform.Rows(data, t.transforms)
 	if err != nil {
-		return err
+		return cp, err
 	}
 
-	bDate, _ := blob.Date()
-	logger.Printf("%s [%s] processedBytes=%d, success=%d, failed=%d\n", datasetName, bDate.Format(time.DateTime), status.ProcessedBytes, status.Ingested, status.Failed)
+	if err := out.Ensure(ctx, t.dataset); err != nil {
+		return cp, err
+	}
 
-	if err := blob.Delete(ctx, azClient); err != nil {
-		return err
+	open := func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
 	}
 
-	return nil
+	started := time.Now()
+	status, err := out.Write(ctx, t.dataset, open)
+	duration := time.Since(started)
+	metrics.IngestDuration.WithLabelValues(t.name).Observe(duration.Seconds())
+	if ctx.Err() == nil {
+		p.health.ingest(err)
+	}
+	if err != nil {
+		return cp, err
+	}
+	p.summary.ingested(t.name, status)
+	span.SetAttributes(statusAttrs(status)...)
+	t.ingested(blob)
+
+	t.log.Info("tailed blob", blobAttrs(blob,
+		"offset", offset,
+		"bytes", status.ProcessedBytes,
+		"rows_ingested", status.Ingested,
+		"rows_failed", status.Failed,
+		"duration", duration,
+		"lag", blobLag(blob),
+	)...)
+
+	if err := p.handleFailures(ctx, blob, t, offset, status, open, src); err != nil {
+		return cp, err
+	}
+
+	return p.checkpoints.SetPartial(ctx, src, cp, blob, next)
+}
+
+// completeBlob deletes a shipped blob, unless blobs are retained, and moves the
+// container's checkpoint past it. ahead is set when an older blob of the
+// container failed, the checkpoint can't move past that one so a retained blob
+// is recorded on its own.
+func (p *Poll) completeBlob(ctx context.Context, blob *monitor.Blob, cp *monitor.Checkpoint, src source.Source, ahead bool) (_ *monitor.Checkpoint, err error) {
+	p.forgetAttempts(blob)
 
+	if p.dryRun {
+		return cp, nil
+	}
+
+	if !p.retain {
+		deleteCtx, span := tracer.Start(ctx, "blob.delete")
+		err := blob.Delete(deleteCtx, src)
+		tracing.End(span, err)
+		if err != nil {
+			metrics.BlobDeleteFailures.WithLabelValues(monitor.ContainerNameToTable(blob.ContainerName())).Inc()
+			return cp, err
+		}
+	}
+
+	if p.checkpoints == nil || (ahead && !p.retain) {
+		return cp, nil
+	}
+
+	ctx, span := tracer.Start(ctx, "checkpoint.set")
+	defer func() { tracing.End(span, err) }()
+
+	if ahead {
+		return p.checkpoints.SetShipped(ctx, src, cp, blob)
+	}
+	if _, err := blob.Date(); err != nil {
+		return p.checkpoints.Skip(ctx, src, cp, blob)
+	}
+	return p.checkpoints.Set(ctx, src, cp, blob)
+}
+
+// countingReader counts the bytes read from a blob as downloaded, and hashes
+// them when there is a digest.
+type countingReader struct {
+	io.ReadCloser
+	table string
+
+	n      int64
+	digest hash.Hash
+	// how long reading waited on the download
+	waited time.Duration
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	started := time.Now()
+	n, err := r.ReadCloser.Read(p)
+	r.waited += time.Since(started)
+	metrics.BytesDownloaded.WithLabelValues(r.table).Add(float64(n))
+	r.n += int64(n)
+	if r.digest != nil {
+		r.digest.Write(p[:n])
+	}
+	return n, err
+}
+
+// blobAttrs returns the attributes logged about a blob, followed by attrs. The
+// container is left to the table's logger.
+func blobAttrs(blob *monitor.Blob, attrs ...any) []any {
+	out := make([]any, 0, 4+len(attrs))
+	out = append(out, "blob", blob.BlobName())
+	if date, err := blob.Date(); err == nil {
+		// the nanoseconds are the number of the blob in its folder
+		out = append(out, "blob_time", date.Truncate(time.Minute))
+	}
+	return append(out, attrs...)
+}
+
+// errBlobGone is returned when the blob being shipped isn't in the source
+// anymore, it is skipped rather than failed.
+var errBlobGone = errors.New("blob is gone")
+
+func goneErr(err error) error {
+	if errors.Is(err, source.ErrNotFound) {
+		return fmt.Errorf("%w: %w", errBlobGone, err)
+	}
+	return err
+}
+
+// blobLag is how long ago the blob's 5 minute window started, which is how far
+// behind its table is while it is the oldest blob being shipped.
+func blobLag(blob *monitor.Blob) time.Duration {
+	date, err := blob.Date()
+	if err != nil {
+		return 0
+	}
+	return time.Since(date).Truncate(time.Second)
+}
+
+// blobSpanAttrs returns the attributes of a span about a blob, followed by attrs.
+func blobSpanAttrs(blob *monitor.Blob, t *table, attrs ...attribute.KeyValue) []attribute.KeyValue {
+	out := make([]attribute.KeyValue, 0, len(t.attrs)+2+len(attrs))
+	out = append(out, t.attrs...)
+	out = append(out, attribute.String("blob", blob.BlobName()))
+	if date, err := blob.Date(); err == nil {
+		out = append(out, attribute.String("blob_time", date.Truncate(time.Minute).Format(time.RFC3339)))
+	}
+	return append(out, attrs...)
+}
+
+func statusAttrs(status *ingest.Status) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.Int64("bytes", int64(status.ProcessedBytes)),
+		attribute.Int64("rows_ingested", int64(status.Ingested)),
+		attribute.Int64("rows_failed", int64(status.Failed)),
+	}
 }
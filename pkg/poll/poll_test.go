@@ -0,0 +1,635 @@
+package poll_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"maps"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/axiomhq/sentinelexport/pkg/axm"
+	"github.com/axiomhq/sentinelexport/pkg/config"
+	"github.com/axiomhq/sentinelexport/pkg/metrics"
+	"github.com/axiomhq/sentinelexport/pkg/monitor"
+	"github.com/axiomhq/sentinelexport/pkg/poll"
+	"github.com/axiomhq/sentinelexport/pkg/sink"
+	"github.com/axiomhq/sentinelexport/pkg/source"
+	"github.com/axiomhq/sentinelexport/pkg/tracing"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	tracepb "go.opentelemetry.io/proto/otlp/trace/v1"
+)
+
+var start = time.Date(2024, 1, 31, 23, 50, 0, 0, time.UTC)
+
+func TestDrainShipsOldestFirst(t *testing.T) {
+	sources(t, func(t *testing.T, src source.Source) {
+		// listed by name, PT05M_10.json comes before PT05M_2.json
+		next := start.Add(15 * time.Minute)
+		seed(t, src, "am-signinlogs", blobName(next, 0), rows(t, row(next, "n", 5)))
+		seed(t, src, "am-signinlogs", blobName(start, 10), rows(t, row(start, "n", 4)))
+		seed(t, src, "am-signinlogs", blobName(start, 2), rows(t, row(start, "n", 3)))
+		seed(t, src, "am-signinlogs", blobName(start, 1), rows(t, row(start, "n", 2)))
+		seed(t, src, "am-signinlogs", blobName(start, 0), rows(t, row(start, "n", 0), row(start, "n", 1)))
+
+		ax := newFakeAxiom(t)
+		summary := drain(t, src, ax.sink(t, ""))
+
+		var got []float64
+		for _, r := range ax.rows("SigninLogs") {
+			got = append(got, r["n"].(float64))
+		}
+		if want := []float64{0, 1, 2, 3, 4, 5}; !slices.Equal(got, want) {
+			t.Errorf("ingested rows %v, want %v", got, want)
+		}
+
+		if left := blobNames(t, src, "am-signinlogs"); len(left) != 0 {
+			t.Errorf("blobs %q were not deleted", left)
+		}
+
+		ts := tableSummary(summary, "SigninLogs")
+		if ts.Blobs != 5 || ts.Ingested != 6 || ts.Failed != 0 || ts.Errors != 0 {
+			t.Errorf("summary %+v, want 5 blobs and 6 rows ingested", ts)
+		}
+		if summary.Failed() {
+			t.Error("summary failed")
+		}
+	})
+}
+
+func TestDrainCreatesMissingDatasets(t *testing.T) {
+	sources(t, func(t *testing.T, src source.Source) {
+		seed(t, src, "am-signinlogs", blobName(start, 0), rows(t, row(start)))
+		seed(t, src, "am-securityevent", blobName(start, 0), rows(t, row(start), row(start)))
+		seed(t, src, "am-auditlogs", blobName(start, 0), rows(t, row(start)))
+
+		ax := newFakeAxiom(t, "az_SecurityEvent")
+		drain(t, src, ax.sink(t, "az_"))
+
+		created := ax.createdDatasets()
+		slices.Sort(created)
+		if want := []string{"az_AuditLogs", "az_SigninLogs"}; !slices.Equal(created, want) {
+			t.Errorf("created datasets %q, want %q", created, want)
+		}
+
+		for dataset, want := range map[string]int{"az_AuditLogs": 1, "az_SecurityEvent": 2, "az_SigninLogs": 1} {
+			if got := len(ax.rows(dataset)); got != want {
+				t.Errorf("ingested %d rows into %q, want %d", got, dataset, want)
+			}
+		}
+	})
+}
+
+func TestDrainCreatesDatasetsWithAPITokens(t *testing.T) {
+	sources(t, func(t *testing.T, src source.Source) {
+		seed(t, src, "am-signinlogs", blobName(start, 0), rows(t, row(start)))
+		seed(t, src, "am-securityevent", blobName(start, 0), rows(t, row(start)))
+
+		ax := newFakeAxiom(t, "SecurityEvent")
+		ax.apiToken = true
+		drain(t, src, ax.sink(t, ""))
+
+		if created, want := ax.createdDatasets(), []string{"SigninLogs"}; !slices.Equal(created, want) {
+			t.Errorf("created datasets %q, want %q", created, want)
+		}
+		for _, dataset := range []string{"SigninLogs", "SecurityEvent"} {
+			if got := len(ax.rows(dataset)); got != 1 {
+				t.Errorf("ingested %d rows into %q, want 1", got, dataset)
+			}
+		}
+	})
+}
+
+func TestDrainAPITokenCanNotCreateDatasets(t *testing.T) {
+	ax := newFakeAxiom(t, "SecurityEvent")
+	ax.apiToken, ax.noCreate = true, true
+	out := ax.sink(t, "")
+
+	ctx := context.Background()
+	open := func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(rows(t, row(start)))), nil
+	}
+
+	// a dataset the token was given access to is assumed to exist
+	if err := out.Ensure(ctx, axm.NewDataset("SecurityEvent")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := out.Write(ctx, axm.NewDataset("SecurityEvent"), open); err != nil {
+		t.Fatal(err)
+	}
+
+	// and a missing one fails the ingest, saying why
+	missing := axm.NewDataset("SigninLogs")
+	if err := out.Ensure(ctx, missing); err != nil {
+		t.Fatal(err)
+	}
+	_, err := out.Write(ctx, missing, open)
+	if want := `dataset "SigninLogs" does not exist and the API token isn't allowed to create it`; err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("error %v, want %s", err, want)
+	}
+}
+
+func TestDrainRetainsBlobs(t *testing.T) {
+	sources(t, func(t *testing.T, src source.Source) {
+		seed(t, src, "am-signinlogs", blobName(start, 0), rows(t, row(start)))
+		seed(t, src, "am-signinlogs", blobName(start.Add(5*time.Minute), 0), rows(t, row(start.Add(5*time.Minute))))
+
+		checkpoints := monitor.NewCheckpointStore("sentinel-sync-checkpoints")
+		if err := checkpoints.Ensure(context.Background(), src); err != nil {
+			t.Fatal(err)
+		}
+
+		ax := newFakeAxiom(t)
+		drain(t, src, ax.sink(t, ""), poll.WithCheckpoints(checkpoints))
+
+		if left := blobNames(t, src, "am-signinlogs"); len(left) != 2 {
+			t.Errorf("blobs %q left, want both", left)
+		}
+
+		var cp monitor.Checkpoint
+		if err := json.Unmarshal(readBlob(t, src, "sentinel-sync-checkpoints", "am-signinlogs.json"), &cp); err != nil {
+			t.Fatal(err)
+		}
+		if want := start.Add(5 * time.Minute); !cp.BlobTime.Equal(want) {
+			t.Errorf("checkpoint at %s, want %s", cp.BlobTime, want)
+		}
+
+		// the checkpoint covers both blobs, so nothing is shipped again
+		summary := drain(t, src, ax.sink(t, ""), poll.WithCheckpoints(checkpoints))
+		if got := len(ax.rows("SigninLogs")); got != 2 {
+			t.Errorf("ingested %d rows, want 2", got)
+		}
+		if ts := tableSummary(summary, "SigninLogs"); ts.Blobs != 0 {
+			t.Errorf("shipped %d blobs again", ts.Blobs)
+		}
+	})
+}
+
+func TestDrainDryRun(t *testing.T) {
+	sources(t, func(t *testing.T, src source.Source) {
+		blobs := map[string][]byte{
+			blobName(start, 0):     append(rows(t, row(start), row(start.Add(time.Minute))), "not json\n"...),
+			"not-data-export.json": rows(t, row(start)),
+		}
+		for name, data := range blobs {
+			seed(t, src, "am-signinlogs", name, data)
+		}
+
+		ctx := context.Background()
+		checkpoints := monitor.NewCheckpointStore("sentinel-sync-checkpoints")
+		if err := checkpoints.Ensure(ctx, src); err != nil {
+			t.Fatal(err)
+		}
+		deadLetters := monitor.NewDeadLetterStore("sentinel-sync-deadletter")
+		if err := deadLetters.Ensure(ctx, src); err != nil {
+			t.Fatal(err)
+		}
+
+		// everything that would otherwise delete, checkpoint or dead-letter a blob
+		out := sink.NewDryRun("az_")
+		drain(t, src, out, poll.WithDryRun(), poll.WithCheckpoints(checkpoints),
+			poll.WithDeadLetters(deadLetters, 1), poll.WithFailurePolicy(poll.FailureDeadLetter))
+
+		for name, data := range blobs {
+			if got := readBlob(t, src, "am-signinlogs", name); !bytes.Equal(got, data) {
+				t.Errorf("blob %q is %q, want %q", name, got, data)
+			}
+		}
+		if got := blobNames(t, src, "am-signinlogs"); len(got) != len(blobs) {
+			t.Errorf("blobs %q left, want %d", got, len(blobs))
+		}
+		for _, container := range []string{"sentinel-sync-checkpoints", "sentinel-sync-deadletter"} {
+			if got := blobNames(t, src, container); len(got) != 0 {
+				t.Errorf("wrote %q to %s", got, container)
+			}
+		}
+
+		report := out.Report()
+		if len(report) != 1 || report[0].Dataset != "az_SigninLogs" || report[0].Rows != 4 || report[0].Invalid != 1 {
+			t.Errorf("dry run report is %+v", report)
+		}
+	})
+}
+
+func TestDrainFailurePolicy(t *testing.T) {
+	reject := func(dataset string, row map[string]any) string {
+		if row["bad"] == true {
+			return "bad row"
+		}
+		return ""
+	}
+
+	sources(t, func(t *testing.T, src source.Source) {
+		for _, policy := range []poll.FailurePolicy{poll.FailureKeep, poll.FailureDeadLetter, poll.FailureDrop} {
+			policy := policy
+
+			t.Run(string(policy), func(t *testing.T) {
+				container := "am-signinlogs" + string(policy)
+				table := monitor.ContainerNameToTable(container)
+				bad := start.Add(time.Second)
+				seed(t, src, container, blobName(start, 0), rows(t, row(start), row(bad, "bad", true)))
+
+				deadLetters := monitor.NewDeadLetterStore("sentinel-sync-deadletter")
+				if err := deadLetters.Ensure(context.Background(), src); err != nil {
+					t.Fatal(err)
+				}
+
+				ax := newFakeAxiom(t)
+				ax.reject = reject
+				summary := drain(t, src, ax.sink(t, ""), poll.WithFailurePolicy(policy), poll.WithDeadLetters(deadLetters, 0))
+
+				if ts := tableSummary(summary, table); ts.Ingested != 1 || ts.Failed != 1 {
+					t.Errorf("summary %+v, want 1 row ingested and 1 failed", ts)
+				}
+
+				left := blobNames(t, src, container)
+				if keep := policy == poll.FailureKeep; keep != (len(left) == 1) {
+					t.Errorf("blobs %q left, want the blob kept=%t", left, keep)
+				}
+
+				var deadLettered []string
+				for _, name := range blobNames(t, src, "sentinel-sync-deadletter") {
+					if strings.HasPrefix(name, container+"/") {
+						deadLettered = append(deadLettered, name)
+					}
+				}
+				if policy != poll.FailureDeadLetter {
+					if len(deadLettered) != 0 {
+						t.Errorf("dead-lettered %q", deadLettered)
+					}
+					return
+				}
+
+				rowsName := container + "/" + blobName(start, 0) + ".0.rows.json"
+				if !slices.Contains(deadLettered, rowsName) {
+					t.Fatalf("dead-lettered %q, want %q", deadLettered, rowsName)
+				}
+				if got, want := string(readBlob(t, src, "sentinel-sync-deadletter", rowsName)), string(rows(t, row(bad, "bad", true))); got != want {
+					t.Errorf("dead-lettered rows %q, want %q", got, want)
+				}
+			})
+		}
+	})
+}
+
+func TestDrainDeadLettersBlobs(t *testing.T) {
+	sources(t, func(t *testing.T, src source.Source) {
+		seed(t, src, "am-signinlogs", blobName(start, 0), rows(t, row(start)))
+		seed(t, src, "am-signinlogs", "not-data-export.json", rows(t, row(start)))
+
+		deadLetters := monitor.NewDeadLetterStore("sentinel-sync-deadletter")
+		if err := deadLetters.Ensure(context.Background(), src); err != nil {
+			t.Fatal(err)
+		}
+
+		// a bad request isn't retried, the blob failed its only attempt
+		ax := newFakeAxiom(t, "SigninLogs")
+		ax.status = 400
+		summary := drain(t, src, ax.sink(t, ""), poll.WithDeadLetters(deadLetters, 1))
+
+		if left := blobNames(t, src, "am-signinlogs"); len(left) != 0 {
+			t.Errorf("blobs %q were not dead-lettered", left)
+		}
+
+		got := blobNames(t, src, "sentinel-sync-deadletter")
+		want := []string{"am-signinlogs/" + blobName(start, 0), "am-signinlogs/not-data-export.json"}
+		for _, name := range want {
+			if !slices.Contains(got, name) {
+				t.Errorf("dead-lettered %q, want %q", got, name)
+			}
+		}
+
+		if !summary.Failed() {
+			t.Error("summary didn't fail")
+		}
+	})
+}
+
+func TestDrainKeepsBlobsWhileAxiomIsDown(t *testing.T) {
+	sources(t, func(t *testing.T, src source.Source) {
+		name := blobName(start, 0)
+		seed(t, src, "am-signinlogs", name, rows(t, row(start)))
+
+		deadLetters := monitor.NewDeadLetterStore("sentinel-sync-deadletter")
+		if err := deadLetters.Ensure(context.Background(), src); err != nil {
+			t.Fatal(err)
+		}
+
+		// an outage isn't the blob's fault, so it never uses up its attempts
+		ax := newFakeAxiom(t, "SigninLogs")
+		ax.status = 503
+		for i := 0; i < 3; i++ {
+			summary := drain(t, src, ax.sink(t, ""), poll.WithDeadLetters(deadLetters, 1))
+			if !summary.Failed() {
+				t.Error("summary didn't fail")
+			}
+		}
+
+		if left := blobNames(t, src, "am-signinlogs"); !slices.Equal(left, []string{name}) {
+			t.Errorf("left blobs %q, want %q", left, name)
+		}
+		if got := blobNames(t, src, "sentinel-sync-deadletter"); len(got) != 0 {
+			t.Errorf("dead-lettered %q", got)
+		}
+	})
+}
+
+func TestDrainWaitsOutRateLimits(t *testing.T) {
+	sources(t, func(t *testing.T, src source.Source) {
+		name := blobName(start, 0)
+		seed(t, src, "am-signinlogs", name, rows(t, row(start)))
+
+		deadLetters := monitor.NewDeadLetterStore("sentinel-sync-deadletter")
+		if err := deadLetters.Ensure(context.Background(), src); err != nil {
+			t.Fatal(err)
+		}
+
+		ax := newFakeAxiom(t, "SigninLogs")
+		ax.status = 429
+		ax.retryAfter = "1"
+		ax.retries = 2
+		started := time.Now()
+		summary := drain(t, src, ax.sink(t, ""), poll.WithDeadLetters(deadLetters, 1))
+
+		if !summary.Failed() {
+			t.Error("summary didn't fail")
+		}
+		if ax.ingests != 2 {
+			t.Errorf("tried %d ingests, want 2", ax.ingests)
+		}
+		if waited := time.Since(started); waited < time.Second {
+			t.Errorf("retried after %s, want at least the 1s Retry-After", waited)
+		}
+
+		// the blob is still pending, a rate limit isn't its fault
+		if left := blobNames(t, src, "am-signinlogs"); !slices.Equal(left, []string{name}) {
+			t.Errorf("left blobs %q, want %q", left, name)
+		}
+		if got := blobNames(t, src, "sentinel-sync-deadletter"); len(got) != 0 {
+			t.Errorf("dead-lettered %q", got)
+		}
+	})
+}
+
+func TestDrainConcurrentBlobsAfterAFailure(t *testing.T) {
+	sources(t, func(t *testing.T, src source.Source) {
+		failing, next := blobName(start, 0), blobName(start.Add(5*time.Minute), 0)
+		seed(t, src, "am-signinlogs", failing, rows(t, row(start, "bad", true)))
+		seed(t, src, "am-signinlogs", next, rows(t, row(start.Add(5*time.Minute))))
+
+		ax := newFakeAxiom(t, "SigninLogs")
+		ax.reject = func(dataset string, row map[string]any) string {
+			if row["bad"] == true {
+				return "bad row"
+			}
+			return ""
+		}
+		tables := poll.WithTables(map[string]config.Table{"SigninLogs": {Concurrency: 2}})
+		drain(t, src, ax.sink(t, ""), tables)
+
+		// the blob shipped alongside the failed one is deleted all the same, so
+		// it isn't ingested again with it
+		if left := blobNames(t, src, "am-signinlogs"); !slices.Equal(left, []string{failing}) {
+			t.Errorf("left blobs %q, want %q", left, failing)
+		}
+
+		ax.reject = nil
+		drain(t, src, ax.sink(t, ""), tables)
+		if got := len(ax.rows("SigninLogs")); got != 2 {
+			t.Errorf("ingested %d rows, want 2", got)
+		}
+	})
+}
+
+func TestDrainRetainsConcurrentBlobsAfterAFailure(t *testing.T) {
+	sources(t, func(t *testing.T, src source.Source) {
+		failing, next, last := blobName(start, 0), blobName(start.Add(5*time.Minute), 0), blobName(start.Add(10*time.Minute), 0)
+		seed(t, src, "am-signinlogs", failing, rows(t, row(start, "bad", true)))
+		seed(t, src, "am-signinlogs", next, rows(t, row(start.Add(5*time.Minute))))
+
+		checkpoints := monitor.NewCheckpointStore("sentinel-sync-checkpoints")
+		if err := checkpoints.Ensure(context.Background(), src); err != nil {
+			t.Fatal(err)
+		}
+		checkpoint := func() monitor.Checkpoint {
+			var cp monitor.Checkpoint
+			if err := json.Unmarshal(readBlob(t, src, "sentinel-sync-checkpoints", "am-signinlogs.json"), &cp); err != nil {
+				t.Fatal(err)
+			}
+			return cp
+		}
+
+		ax := newFakeAxiom(t, "SigninLogs")
+		ax.reject = func(dataset string, row map[string]any) string {
+			if row["bad"] == true {
+				return "bad row"
+			}
+			return ""
+		}
+		options := []poll.Option{
+			poll.WithCheckpoints(checkpoints),
+			poll.WithTables(map[string]config.Table{"SigninLogs": {Concurrency: 2}}),
+		}
+		drain(t, src, ax.sink(t, ""), options...)
+
+		// the checkpoint can't move past the failed blob, so the one shipped
+		// alongside it is recorded on its own
+		if cp := checkpoint(); !cp.BlobTime.IsZero() || !slices.Equal(cp.Shipped, []string{next}) {
+			t.Errorf("checkpoint at %s with %q shipped, want only %q shipped", cp.BlobTime, cp.Shipped, next)
+		}
+
+		ax.reject = nil
+		seed(t, src, "am-signinlogs", last, rows(t, row(start.Add(10*time.Minute))))
+		drain(t, src, ax.sink(t, ""), options...)
+		if got := len(ax.rows("SigninLogs")); got != 3 {
+			t.Errorf("ingested %d rows, want 3", got)
+		}
+
+		// once the checkpoint caught up it covers the blob by its time
+		if cp := checkpoint(); !cp.BlobTime.Equal(start.Add(10*time.Minute)) || len(cp.Shipped) != 0 {
+			t.Errorf("checkpoint at %s with %q shipped, want it at the last blob", cp.BlobTime, cp.Shipped)
+		}
+	})
+}
+
+func TestDrainAudits(t *testing.T) {
+	sources(t, func(t *testing.T, src source.Source) {
+		first := rows(t, row(start, "n", 0))
+		second := rows(t, row(start.Add(5*time.Minute), "n", 1), row(start.Add(5*time.Minute), "n", 2))
+		seed(t, src, "am-signinlogs", blobName(start, 0), first)
+		seed(t, src, "am-signinlogs", blobName(start.Add(5*time.Minute), 0), second)
+
+		ax := newFakeAxiom(t)
+		audit := axm.NewDataset("sentinel-sync-audit")
+		audit.TimestampField = "_time"
+		audit.Unprefixed = true
+		checkpoints := monitor.NewCheckpointStore("sentinel-sync-checkpoints")
+		if err := checkpoints.Ensure(context.Background(), src); err != nil {
+			t.Fatal(err)
+		}
+		drain(t, src, ax.sink(t, "az_"), poll.WithAudit(ax.client(t, "az_"), audit, checkpoints))
+
+		events := ax.rows("sentinel-sync-audit")
+		if len(events) != 2 {
+			t.Fatalf("wrote %d audit events, want 2", len(events))
+		}
+
+		previous := ""
+		for i, data := range [][]byte{first, second} {
+			e := events[i]
+			sum := sha256.Sum256(data)
+			if e["dataset"] != "az_SigninLogs" {
+				t.Errorf("audit event %d is for dataset %v, want az_SigninLogs", i, e["dataset"])
+			}
+			if e["outcome"] != "shipped" || e["deleted"] != true || e["sha256"] != hex.EncodeToString(sum[:]) || e["bytes"] != float64(len(data)) {
+				t.Errorf("audit event %d is %v", i, e)
+			}
+			if e["sequence"] != float64(i+1) || e["previous_hash"] != previous {
+				t.Errorf("audit event %d isn't chained to %q: %v", i, previous, e)
+			}
+			if !verifyAudit(t, e) {
+				t.Errorf("audit event %d doesn't match its hash: %v", i, e)
+			}
+			previous, _ = e["hash"].(string)
+		}
+
+		// the next run carries on with the chain
+		third := rows(t, row(start.Add(10*time.Minute), "n", 3))
+		seed(t, src, "am-signinlogs", blobName(start.Add(10*time.Minute), 0), third)
+		drain(t, src, ax.sink(t, "az_"), poll.WithAudit(ax.client(t, "az_"), audit, checkpoints))
+
+		events = ax.rows("sentinel-sync-audit")
+		if len(events) != 3 {
+			t.Fatalf("wrote %d audit events, want 3", len(events))
+		}
+		if e := events[2]; e["sequence"] != float64(3) || e["previous_hash"] != previous || e["run_id"] == events[1]["run_id"] {
+			t.Errorf("audit event of the next run isn't chained to %q: %v", previous, e)
+		}
+
+		// changing any field of an event, however deep, breaks its hash
+		e := events[1]
+		var tamper func(fields map[string]any, path string)
+		tamper = func(fields map[string]any, path string) {
+			for key, value := range fields {
+				if nested, ok := value.(map[string]any); ok {
+					tamper(nested, path+key+".")
+					continue
+				}
+				if path == "" && key == "hash" {
+					continue
+				}
+
+				fields[key] = tampered(value)
+				if verifyAudit(t, e) {
+					t.Errorf("changing %s%s to %v didn't break the hash", path, key, fields[key])
+				}
+				fields[key] = value
+			}
+		}
+		tamper(e, "")
+		if !verifyAudit(t, e) {
+			t.Error("audit event doesn't match its hash after undoing the changes")
+		}
+	})
+}
+
+// verifyAudit checks the hash of an audit event as read back from axiom, the way
+// the theory of operation describes.
+func verifyAudit(t *testing.T, e map[string]any) bool {
+	t.Helper()
+
+	fields := maps.Clone(e)
+	delete(fields, "hash")
+
+	var canonical bytes.Buffer
+	enc := json.NewEncoder(&canonical)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(fields); err != nil {
+		t.Fatal(err)
+	}
+
+	sum := sha256.Sum256(bytes.TrimSuffix(canonical.Bytes(), []byte("\n")))
+	return e["hash"] == hex.EncodeToString(sum[:])
+}
+
+func tampered(v any) any {
+	switch v := v.(type) {
+	case string:
+		return v + "x"
+	case float64:
+		return v + 1
+	case bool:
+		return !v
+	}
+	return "x"
+}
+
+func TestDrainTraces(t *testing.T) {
+	collector := newFakeCollector(t)
+	shutdown, err := tracing.Setup(context.Background(), collector.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = shutdown(context.Background()) })
+
+	src := source.NewDir(t.TempDir())
+	seed(t, src, "am-signinlogs", blobName(start, 0), rows(t, row(start)))
+
+	ax := newFakeAxiom(t)
+	drain(t, src, ax.sink(t, ""))
+
+	// shutting down sends the spans that are left
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	byName := map[string]*tracepb.Span{}
+	for _, span := range collector.received() {
+		byName[span.Name] = span
+	}
+
+	// every phase of the blob is a child of its span, which is a child of the
+	// container's
+	parents := map[string]string{
+		"poll.blob":    "poll.container",
+		"blob.ingest":  "poll.blob",
+		"blob.open":    "poll.blob",
+		"axiom.ingest": "blob.ingest",
+		"blob.delete":  "poll.blob",
+	}
+	for name, parent := range parents {
+		span, ok := byName[name]
+		if !ok {
+			t.Errorf("no %q span", name)
+			continue
+		}
+		if want := byName[parent]; want == nil || !slices.Equal(span.ParentSpanId, want.SpanId) {
+			t.Errorf("%q span isn't a child of %q", name, parent)
+		}
+	}
+	if _, ok := byName["poll.cycle"]; !ok {
+		t.Error("no poll.cycle span")
+	}
+}
+
+func TestDrainFlagsStaleTables(t *testing.T) {
+	sources(t, func(t *testing.T, src source.Source) {
+		// the newest blob is from long before the stale period
+		seed(t, src, "am-signinlogs", blobName(start, 0), rows(t, row(start)))
+		seed(t, src, "am-auditlogs", blobName(time.Now().UTC().Add(-time.Hour), 0), rows(t, row(start)))
+
+		ax := newFakeAxiom(t)
+		drain(t, src, ax.sink(t, ""), poll.WithStaleAfter(24*time.Hour))
+
+		for table, want := range map[string]float64{"SigninLogs": 1, "AuditLogs": 0} {
+			if got := testutil.ToFloat64(metrics.TableStale.WithLabelValues(table)); got != want {
+				t.Errorf("%s stale is %v, want %v", table, got, want)
+			}
+		}
+	})
+}
@@ -0,0 +1,590 @@
+package poll
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/axiomhq/sentinelexport/pkg/metrics"
+	"github.com/axiomhq/sentinelexport/pkg/monitor"
+	"github.com/axiomhq/sentinelexport/pkg/sink"
+	"github.com/axiomhq/sentinelexport/pkg/source"
+	"github.com/axiomhq/sentinelexport/pkg/tracing"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// scheduler runs a loop per container for as long as the container exists, so a
+// container with a big backlog doesn't hold up the others. The loops share the
+// worker slots, taking turns after every blob.
+type scheduler struct {
+	p   *Poll
+	src source.Source
+	out sink.Sink
+	sam *monitor.StorageAccountMonitor
+
+	// blobs are discovered from events rather than by listing every container
+	events bool
+	// every container is synced once, see Poll.Drain
+	once  bool
+	slots *fairSlots
+
+	mu      sync.Mutex
+	loops   map[string]*containerLoop
+	skipped map[string]struct{}
+	wg      sync.WaitGroup
+}
+
+func newScheduler(p *Poll, src source.Source, out sink.Sink, sam *monitor.StorageAccountMonitor) *scheduler {
+	return &scheduler{
+		p:       p,
+		src:     src,
+		out:     out,
+		sam:     sam,
+		events:  p.queue != nil,
+		slots:   newFairSlots(p.wpsize),
+		loops:   map[string]*containerLoop{},
+		skipped: map[string]struct{}{},
+	}
+}
+
+func (s *scheduler) run(ctx context.Context) {
+	defer s.wg.Wait()
+
+	if s.events {
+		s.runEvents(ctx)
+		return
+	}
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := s.discover(ctx); err != nil && !errors.Is(err, context.Canceled) {
+			logger.Error("can not list containers", "error", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// drain syncs every container once and waits for them to finish.
+func (s *scheduler) drain(ctx context.Context) error {
+	// only what the listing finds is drained
+	s.events = false
+	defer s.wg.Wait()
+
+	if _, err := s.discover(ctx); err != nil {
+		return fmt.Errorf("can not list containers: %w", err)
+	}
+
+	return nil
+}
+
+// discover starts a loop for every new container and stops the loops of
+// containers that are gone.
+func (s *scheduler) discover(ctx context.Context) (_ []*monitor.ContainerMonitor, err error) {
+	// the loops outlive the cycle, so they are started without its span
+	cycleCtx, span := tracer.Start(ctx, "poll.cycle")
+	defer func() { tracing.End(span, err) }()
+
+	containers, err := s.sam.ListContainers(cycleCtx, s.src)
+	if ctx.Err() == nil {
+		s.p.health.listing(err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	enabled := containers[:0]
+	listed := make(map[string]struct{}, len(containers))
+	for _, container := range containers {
+		if !s.p.enabled(container) {
+			s.disabled(container)
+			continue
+		}
+
+		enabled = append(enabled, container)
+		listed[container.ContainerName()] = struct{}{}
+		s.loop(ctx, container)
+	}
+	span.SetAttributes(attribute.Int("containers", len(enabled)))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, c := range s.loops {
+		if _, ok := listed[name]; !ok {
+			c.table.log.Info("container is gone, stopping")
+			c.cancel()
+			delete(s.loops, name)
+			metrics.Backlog.DeleteLabelValues(name, c.table.name)
+			metrics.TableStale.DeleteLabelValues(c.table.name)
+			metrics.ForgetTable(c.table.name)
+		}
+	}
+
+	return enabled, nil
+}
+
+// disabled logs that a container is skipped, the first time it is seen.
+func (s *scheduler) disabled(container *monitor.ContainerMonitor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.skipped[container.ContainerName()]; ok {
+		return
+	}
+	s.skipped[container.ContainerName()] = struct{}{}
+	logger.Info("skipping container, table is disabled or filtered out", "container", container.ContainerName(), "table", container.TableName())
+}
+
+// loop returns the loop of the container, starting it if it isn't running yet.
+func (s *scheduler) loop(ctx context.Context, container *monitor.ContainerMonitor) *containerLoop {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if c, ok := s.loops[container.ContainerName()]; ok {
+		return c
+	}
+
+	c := &containerLoop{
+		s:         s,
+		container: container,
+		table:     s.p.table(container),
+		wake:      make(chan struct{}, 1),
+		started:   time.Now(),
+		pending:   map[string]*pendingBlob{},
+		deleted:   map[string]time.Time{},
+	}
+
+	var loopCtx context.Context
+	loopCtx, c.cancel = context.WithCancel(ctx)
+	s.loops[container.ContainerName()] = c
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		c.run(loopCtx)
+	}()
+
+	return c
+}
+
+type pendingBlob struct {
+	blob  *monitor.Blob
+	added time.Time
+}
+
+// containerLoop ships the blobs of a single container oldest to newest.
+type containerLoop struct {
+	s         *scheduler
+	container *monitor.ContainerMonitor
+	table     *table
+	cancel    context.CancelFunc
+	wake      chan struct{}
+
+	// only used with a checkpoint store, only touched by the loop itself
+	checkpoint       *monitor.Checkpoint
+	checkpointLoaded bool
+
+	// the time of the newest blob seen, and whether the table is stale, only
+	// touched by the loop itself
+	started time.Time
+	newest  time.Time
+	stale   bool
+
+	// with events, the blobs known to be in the container
+	mu      sync.Mutex
+	pending map[string]*pendingBlob
+	// blobs that were shipped and deleted, kept until a listing that started
+	// after the delete so a stale listing can't reschedule them
+	deleted map[string]time.Time
+}
+
+func (c *containerLoop) run(ctx context.Context) {
+	c.table.log.Info("syncing container")
+	if !c.container.IsKnownTable() {
+		// e.g. a table Data Export added support for since, or a container
+		// that isn't from Data Export at all
+		c.table.log.Warn("table is not known to be exported by Data Export, its name is made up from the container name")
+	}
+
+	for {
+		wait := c.sync(ctx)
+		if c.s.once {
+			return
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-c.wake:
+		case <-time.After(wait):
+		}
+	}
+}
+
+// poke wakes the loop up if it is waiting.
+func (c *containerLoop) poke() {
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+}
+
+// sync ships every blob of the container that is ready, and returns how long to
+// wait before trying again.
+func (c *containerLoop) sync(ctx context.Context) time.Duration {
+	p := c.s.p
+
+	ctx, span := tracer.Start(ctx, "poll.container", trace.WithAttributes(c.table.attrs...))
+	defer span.End()
+
+	if p.checkpoints != nil && !c.checkpointLoaded {
+		cp, err := p.checkpoints.Get(ctx, c.s.src, c.container.ContainerName())
+		if err != nil {
+			c.table.log.Error("can not get checkpoint", "error", err)
+			tracing.Fail(span, err)
+			p.summary.failed(c.table.name)
+			return pollInterval
+		}
+		c.checkpoint = cp
+		c.checkpointLoaded = true
+	}
+
+	blobs, err := c.blobs(ctx)
+	if err != nil {
+		c.table.log.Error("can not list blobs", "error", err)
+		tracing.Fail(span, err)
+		p.summary.failed(c.table.name)
+		return pollInterval
+	}
+	span.SetAttributes(attribute.Int("blobs", len(blobs)))
+	c.seen(blobs)
+	c.checkStale()
+
+	for len(blobs) > 0 {
+		c.backlog(blobs)
+		if ctx.Err() != nil {
+			return 0
+		}
+
+		batch, wait, err := c.ready(ctx, blobs)
+		if errors.Is(err, errBlobGone) {
+			c.gone(blobs[0])
+			blobs = blobs[1:]
+			continue
+		}
+		if err != nil {
+			c.table.log.Error("can not check blob is settled", blobAttrs(blobs[0], "error", err)...)
+			if wait, next := c.failed(ctx, blobs[0], nil, err, false); !next {
+				return wait
+			}
+			blobs = blobs[1:]
+			continue
+		}
+		if len(batch) == 0 {
+			return wait
+		}
+
+		if wait, next := c.ship(ctx, batch); !next {
+			return wait
+		}
+		blobs = blobs[len(batch):]
+	}
+	c.backlog(nil)
+
+	return pollInterval
+}
+
+// backlog updates the metrics of the blobs left to ship.
+func (c *containerLoop) backlog(blobs []*monitor.Blob) {
+	metrics.Backlog.WithLabelValues(c.container.ContainerName(), c.table.name).Set(float64(len(blobs)))
+
+	// blobs with an invalid name come first and have no time
+	var oldest time.Time
+	for _, blob := range blobs {
+		if date, err := blob.Date(); err == nil {
+			oldest = date
+			break
+		}
+	}
+	metrics.SetOldestPending(c.table.name, oldest)
+}
+
+// blobs returns the blobs to ship in the order they must be shipped.
+func (c *containerLoop) blobs(ctx context.Context) ([]*monitor.Blob, error) {
+	if !c.s.events {
+		// list once and work through the listing, rather than listing again
+		// for every blob
+		return c.container.ListPendingBlobs(ctx, c.s.src, c.checkpoint)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var invalid, pending []*monitor.Blob
+	for name, pb := range c.pending {
+		covered, err := c.checkpoint.Covers(pb.blob)
+		if err != nil && !errors.Is(err, monitor.ErrInvalidBlobName) {
+			return nil, err
+		}
+		if covered {
+			delete(c.pending, name)
+			continue
+		}
+
+		if _, err := pb.blob.Date(); err != nil {
+			invalid = append(invalid, pb.blob)
+			continue
+		}
+		pending = append(pending, pb.blob)
+	}
+
+	monitor.SortBlobs(pending)
+	return append(invalid, pending...), nil
+}
+
+// ready returns the blobs at the start of blobs that have settled, up to the
+// concurrency of the table. Blobs have to be shipped in order, so if the oldest
+// one hasn't settled none are, it is tailed instead and wait is how long until
+// it settles.
+func (c *containerLoop) ready(ctx context.Context, blobs []*monitor.Blob) (batch []*monitor.Blob, wait time.Duration, err error) {
+	batch = blobs[:min(len(blobs), c.table.concurrency)]
+
+	for i, blob := range batch {
+		settledAt, err := c.settledAt(ctx, blob)
+		if err != nil {
+			if i > 0 {
+				// checked again at the start of the next batch
+				return batch[:i], 0, nil
+			}
+			return nil, 0, err
+		}
+
+		if wait := time.Until(settledAt); wait > 0 {
+			if i > 0 {
+				return batch[:i], 0, nil
+			}
+
+			c.tail(ctx, blob)
+			return nil, min(wait, pollInterval), nil
+		}
+	}
+
+	return batch, 0, nil
+}
+
+func (c *containerLoop) tail(ctx context.Context, blob *monitor.Blob) {
+	p := c.s.p
+	if !p.tail || p.dryRun {
+		return
+	}
+
+	if err := c.s.slots.acquire(ctx); err != nil {
+		return
+	}
+	defer c.s.slots.release()
+
+	cp, err := p.tailBlob(ctx, blob, c.table, c.s.src, c.s.out, c.checkpoint)
+	if err != nil {
+		c.table.log.Error("can not tail blob", blobAttrs(blob, "error", err)...)
+		cp = p.tailFailed(ctx, blob, c.table, cp, err, c.s.src)
+	}
+	c.checkpoint = cp
+}
+
+// ship ships the batch of blobs at once, each using one of the worker slots,
+// then completes them in order. next reports whether the loop can carry on with
+// the next batch, otherwise it has to wait.
+func (c *containerLoop) ship(ctx context.Context, batch []*monitor.Blob) (wait time.Duration, next bool) {
+	p := c.s.p
+
+	errs := make([]error, len(batch))
+	shipped := make([]*shipment, len(batch))
+
+	// a blob's span covers shipping and completing it, the blobs that aren't
+	// completed are shipped again later
+	ctxs := make([]context.Context, len(batch))
+	spans := make([]trace.Span, len(batch))
+	for i, blob := range batch {
+		ctxs[i], spans[i] = tracer.Start(ctx, "poll.blob", trace.WithAttributes(blobSpanAttrs(blob, c.table)...))
+	}
+	defer func() {
+		for _, span := range spans {
+			span.End()
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for i, blob := range batch {
+		i, blob := i, blob
+		ctx := ctxs[i]
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := c.s.slots.acquire(ctx); err != nil {
+				errs[i] = err
+				return
+			}
+			defer c.s.slots.release()
+
+			shipped[i], errs[i] = p.shipBlob(ctx, blob, c.table, c.checkpoint.Offset(blob), c.s.src, c.s.out)
+		}()
+	}
+	wg.Wait()
+
+	// a checkpoint must not move past a blob that failed, so the blobs after it
+	// that shipped are recorded in it on their own, or deleted when blobs aren't
+	// retained, and aren't ingested twice
+	var failed bool
+	for i, blob := range batch {
+		if ctx.Err() != nil {
+			return 0, false
+		}
+
+		if errors.Is(errs[i], errBlobGone) {
+			c.gone(blob)
+			continue
+		}
+		if errs[i] != nil {
+			c.table.log.Error("can not ship blob", blobAttrs(blob, "error", errs[i])...)
+			tracing.Fail(spans[i], errs[i])
+			if w, next := c.failed(ctxs[i], blob, shipped[i], errs[i], failed); !next {
+				wait, failed = w, true
+			}
+			continue
+		}
+
+		var err error
+		c.checkpoint, err = p.completeBlob(ctxs[i], blob, c.checkpoint, c.s.src, failed)
+		if err != nil {
+			c.table.log.Error("can not complete blob", blobAttrs(blob, "error", err)...)
+			tracing.Fail(spans[i], err)
+			p.summary.failed(c.table.name)
+			return pollInterval, false
+		}
+
+		p.summary.shipped(c.table.name)
+		p.record(ctxs[i], blob, c.table, shipped[i], outcomeShipped, nil, c.s.src)
+		c.done(blob)
+	}
+
+	return wait, !failed
+}
+
+func (c *containerLoop) settledAt(ctx context.Context, blob *monitor.Blob) (time.Time, error) {
+	if c.s.events {
+		// events don't carry the blob's properties, and they change while
+		// Data Export appends to it, so always look at the latest ones
+		if err := blob.LoadProperties(ctx, c.s.src); err != nil {
+			return time.Time{}, goneErr(err)
+		}
+	}
+
+	return c.s.p.settle.SettledAt(blob)
+}
+
+// failed hands a blob that failed to ship to the poller, the loop can carry on
+// if it was dead-lettered. ahead is set when an older blob of the batch failed.
+func (c *containerLoop) failed(ctx context.Context, blob *monitor.Blob, shipped *shipment, cause error, ahead bool) (time.Duration, bool) {
+	c.s.p.summary.failed(c.table.name)
+
+	var next bool
+	c.checkpoint, next = c.s.p.blobFailed(ctx, blob, c.table, c.checkpoint, cause, c.s.src, ahead)
+	switch {
+	case next:
+		c.s.p.record(ctx, blob, c.table, shipped, outcomeDeadLettered, cause, c.s.src)
+		c.done(blob)
+	case shipped != nil:
+		// a blob that could not even be checked isn't audited until it is
+		// dead-lettered
+		c.s.p.record(ctx, blob, c.table, shipped, outcomeFailed, cause, c.s.src)
+	}
+	return pollInterval, next
+}
+
+// gone drops a blob that isn't in the container anymore, usually because it was
+// already shipped when an event for it was delivered again.
+func (c *containerLoop) gone(blob *monitor.Blob) {
+	c.table.log.Info("blob is gone, skipping", blobAttrs(blob)...)
+	c.done(blob)
+}
+
+func (c *containerLoop) done(blob *monitor.Blob) {
+	if !c.s.events {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.pending, blob.BlobName())
+	c.deleted[blob.BlobName()] = time.Now()
+}
+
+// fairSlots hands out the worker slots first come first served, so a container
+// with a big backlog queues up behind the other containers after every blob
+// rather than keeping its slot.
+type fairSlots struct {
+	mu      sync.Mutex
+	free    int
+	waiters []chan struct{}
+}
+
+func newFairSlots(size int) *fairSlots {
+	return &fairSlots{
+		free: size,
+	}
+}
+
+func (f *fairSlots) acquire(ctx context.Context) error {
+	f.mu.Lock()
+	if f.free > 0 && len(f.waiters) == 0 {
+		f.free--
+		f.mu.Unlock()
+		return nil
+	}
+
+	ready := make(chan struct{})
+	f.waiters = append(f.waiters, ready)
+	f.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+		f.mu.Lock()
+		if i := slices.Index(f.waiters, ready); i >= 0 {
+			f.waiters = slices.Delete(f.waiters, i, i+1)
+			f.mu.Unlock()
+			return ctx.Err()
+		}
+		f.mu.Unlock()
+
+		// the slot was handed over just as we gave up, pass it on
+		f.release()
+		return ctx.Err()
+	}
+}
+
+func (f *fairSlots) release() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if len(f.waiters) > 0 {
+		close(f.waiters[0])
+		f.waiters = f.waiters[1:]
+		return
+	}
+	f.free++
+}
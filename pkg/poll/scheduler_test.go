@@ -0,0 +1,72 @@
+package poll
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestFairSlotsTakeTurns(t *testing.T) {
+	ctx := context.Background()
+	slots := newFairSlots(1)
+
+	var (
+		mu    sync.Mutex
+		order []string
+	)
+	ship := func(container string) {
+		mu.Lock()
+		defer mu.Unlock()
+		order = append(order, container)
+	}
+
+	// the container with the backlog has the only slot when the others queue up
+	if err := slots.acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for _, container := range []string{"am-a", "am-b"} {
+		container := container
+		waiting := len(slots.waiting()) + 1
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := slots.acquire(ctx); err != nil {
+				t.Error(err)
+				return
+			}
+			defer slots.release()
+			ship(container)
+		}()
+
+		for len(slots.waiting()) < waiting {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	for i := 0; i < 3; i++ {
+		ship("am-backlog")
+		slots.release()
+		if err := slots.acquire(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	slots.release()
+	wg.Wait()
+
+	want := []string{"am-backlog", "am-a", "am-b", "am-backlog", "am-backlog"}
+	if !slices.Equal(order, want) {
+		t.Errorf("shipped %q, want %q", order, want)
+	}
+}
+
+func (f *fairSlots) waiting() []chan struct{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	return slices.Clone(f.waiters)
+}
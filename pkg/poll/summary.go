@@ -0,0 +1,104 @@
+package poll
+
+import (
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/axiomhq/axiom-go/axiom/ingest"
+	"github.com/axiomhq/sentinelexport/pkg/metrics"
+)
+
+// TableSummary is what has been exported of a table.
+type TableSummary struct {
+	Table string
+	// Blobs is the number of blobs that were exported completely.
+	Blobs int
+	// Bytes is the number of bytes axiom processed.
+	Bytes uint64
+	// Ingested and Failed are the number of rows axiom ingested and rejected.
+	Ingested uint64
+	Failed   uint64
+	// Errors is the number of times exporting a blob, or listing the blobs of
+	// the table, failed.
+	Errors int
+}
+
+// Summary keeps track of what has been exported of every table, and updates the
+// metrics to match.
+type Summary struct {
+	mu     sync.Mutex
+	tables map[string]*TableSummary
+}
+
+func newSummary() *Summary {
+	return &Summary{
+		tables: map[string]*TableSummary{},
+	}
+}
+
+// get must be called with s.mu held.
+func (s *Summary) get(table string) *TableSummary {
+	ts, ok := s.tables[table]
+	if !ok {
+		ts = &TableSummary{Table: table}
+		s.tables[table] = ts
+	}
+	return ts
+}
+
+func (s *Summary) ingested(table string, status *ingest.Status) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ts := s.get(table)
+	ts.Bytes += status.ProcessedBytes
+	ts.Ingested += status.Ingested
+	ts.Failed += status.Failed
+
+	metrics.RowsIngested.WithLabelValues(table).Add(float64(status.Ingested))
+	metrics.RowsFailed.WithLabelValues(table).Add(float64(status.Failed))
+}
+
+func (s *Summary) shipped(table string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.get(table).Blobs++
+	metrics.BlobsProcessed.WithLabelValues(table).Inc()
+}
+
+func (s *Summary) failed(table string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.get(table).Errors++
+	metrics.BlobErrors.WithLabelValues(table).Inc()
+}
+
+// Tables returns the summary of every table that had anything to export, by
+// table name.
+func (s *Summary) Tables() []TableSummary {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	tables := make([]TableSummary, 0, len(s.tables))
+	for _, ts := range s.tables {
+		tables = append(tables, *ts)
+	}
+	slices.SortFunc(tables, func(a, b TableSummary) int {
+		return strings.Compare(a.Table, b.Table)
+	})
+
+	return tables
+}
+
+// Failed reports whether any blob failed to export or any row was rejected.
+func (s *Summary) Failed() bool {
+	for _, ts := range s.Tables() {
+		if ts.Errors > 0 || ts.Failed > 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package poll
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/axiomhq/sentinelexport/pkg/axm"
+	"github.com/axiomhq/sentinelexport/pkg/config"
+	"github.com/axiomhq/sentinelexport/pkg/monitor"
+	"github.com/axiomhq/sentinelexport/pkg/transform"
+	"go.opentelemetry.io/otel/attribute"
+)
+
+// table is how the blobs of a container are exported.
+type table struct {
+	name        string
+	dataset     *axm.Dataset
+	concurrency int
+	transforms  []transform.Transform
+
+	// log has the container, table and dataset on every line, and attrs has
+	// them for every span
+	log   *slog.Logger
+	attrs []attribute.KeyValue
+}
+
+// WithTables sets how each table is exported, keyed by table name. Tables that
+// aren't in the map are exported with the defaults.
+func WithTables(tables map[string]config.Table) Option {
+	return func(p *Poll) {
+		p.tables = tables
+	}
+}
+
+// WithTableFilter only exports the tables the filter matches.
+func WithTableFilter(filter *monitor.TableFilter) Option {
+	return func(p *Poll) {
+		p.filter = filter
+	}
+}
+
+// enabled reports whether the blobs of the container should be exported.
+func (p *Poll) enabled(container *monitor.ContainerMonitor) bool {
+	return p.filter.Match(container.TableName()) && p.tables[container.TableName()].IsEnabled()
+}
+
+func (p *Poll) table(container *monitor.ContainerMonitor) *table {
+	settings := p.tables[container.TableName()]
+
+	name := settings.Dataset
+	if name == "" {
+		name = container.TableName()
+	}
+	dataset := axm.NewDataset(name)
+	dataset.TimestampField = settings.TimestampField
+	dataset.TimestampFormat = settings.TimestampFormat
+
+	t := &table{
+		name:        container.TableName(),
+		dataset:     dataset,
+		concurrency: max(settings.Concurrency, 1),
+		transforms:  settings.Transforms,
+		log:         logger.With("container", container.ContainerName(), "table", container.TableName(), "dataset", name),
+		attrs: []attribute.KeyValue{
+			attribute.String("container", container.ContainerName()),
+			attribute.String("table", container.TableName()),
+			attribute.String("dataset", name),
+		},
+	}
+
+	// after the transforms, so the timestamp settings are about the rows as
+	// they are ingested
+	if len(settings.TimestampFallbacks) > 0 {
+		t.transforms = append(slices.Clip(t.transforms), transform.Transform{
+			Coalesce: map[string][]string{t.dataset.TimestampFieldName(): settings.TimestampFallbacks},
+		})
+	}
+
+	return t
+}
+
+// timestampLayout is how failed rows are told apart by their timestamp.
+func (t *table) timestampLayout() string {
+	if t.dataset.TimestampFormat == "" {
+		return time.RFC3339Nano
+	}
+	return t.dataset.TimestampFormat
+}
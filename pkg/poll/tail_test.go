@@ -0,0 +1,175 @@
+package poll_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/axiomhq/sentinelexport/pkg/monitor"
+	"github.com/axiomhq/sentinelexport/pkg/poll"
+	"github.com/axiomhq/sentinelexport/pkg/source"
+)
+
+// appendSource makes every blob of the source an append blob, the only blobs
+// that are tailed, as Data Export writes them.
+type appendSource struct {
+	source.Source
+}
+
+func (s appendSource) ListBlobs(ctx context.Context, container string) ([]*source.Properties, error) {
+	blobs, err := s.Source.ListBlobs(ctx, container)
+	for _, props := range blobs {
+		props.Append = true
+	}
+	return blobs, err
+}
+
+func (s appendSource) Properties(ctx context.Context, container, name string) (*source.Properties, error) {
+	props, err := s.Source.Properties(ctx, container, name)
+	if props != nil {
+		props.Append = true
+	}
+	return props, err
+}
+
+// rangeSource fails to open a blob at its end, as azure answers a range past
+// the end of the blob with 416 InvalidRange.
+type rangeSource struct {
+	source.Source
+}
+
+func (s rangeSource) Open(ctx context.Context, container, name string, offset int64) (io.ReadCloser, error) {
+	props, err := s.Source.Properties(ctx, container, name)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 && offset >= props.Size {
+		return nil, fmt.Errorf("range %d- is past the end of blob %q", offset, name)
+	}
+	return s.Source.Open(ctx, container, name, offset)
+}
+
+func TestTailShipsCompleteLines(t *testing.T) {
+	sources(t, func(t *testing.T, src source.Source) {
+		src = appendSource{src}
+
+		checkpoints := monitor.NewCheckpointStore("sentinel-sync-checkpoints")
+		if err := checkpoints.Ensure(context.Background(), src); err != nil {
+			t.Fatal(err)
+		}
+
+		// the current window, so the blob is still being appended to
+		window := time.Now().UTC().Truncate(5 * time.Minute)
+		name := blobName(window, 0)
+		first, second, third := rows(t, row(window, "n", 0)), rows(t, row(window, "n", 1)), rows(t, row(window, "n", 2))
+
+		ax := newFakeAxiom(t)
+		ns := func() []float64 {
+			var got []float64
+			for _, r := range ax.rows("SigninLogs") {
+				got = append(got, r["n"].(float64))
+			}
+			return got
+		}
+
+		// the last line is still being written
+		seed(t, src, "am-signinlogs", name, append(slices.Clip(first), second[:10]...))
+		drain(t, src, ax.sink(t, ""), poll.WithTailing(checkpoints))
+		if got, want := ns(), []float64{0}; !slices.Equal(got, want) {
+			t.Fatalf("tailed rows %v, want %v", got, want)
+		}
+
+		var cp monitor.Checkpoint
+		if err := json.Unmarshal(readBlob(t, src, "sentinel-sync-checkpoints", "am-signinlogs.json"), &cp); err != nil {
+			t.Fatal(err)
+		}
+		if cp.Partial == nil || cp.Partial.Offset != int64(len(first)) {
+			t.Fatalf("checkpoint %+v, want the blob shipped up to %d", cp.Partial, len(first))
+		}
+
+		// the blob grew between polls, a new poller carries on from the offset
+		grown := append(append(slices.Clip(first), second...), third...)
+		seed(t, src, "am-signinlogs", name, grown)
+		drain(t, src, ax.sink(t, ""), poll.WithTailing(checkpoints))
+		if got, want := ns(), []float64{0, 1, 2}; !slices.Equal(got, want) {
+			t.Fatalf("tailed rows %v, want %v", got, want)
+		}
+
+		// nothing new, nothing is shipped
+		drain(t, src, ax.sink(t, ""), poll.WithTailing(checkpoints))
+		if got := len(ns()); got != 3 {
+			t.Fatalf("tailed %d rows, want 3", got)
+		}
+
+		// once the blob settles it is completed without downloading or shipping
+		// its rows again
+		settled := monitor.SettlePolicy{Grace: -time.Hour}
+		drain(t, rangeSource{src}, ax.sink(t, ""), poll.WithTailing(checkpoints), poll.WithSettlePolicy(settled))
+		if got, want := ns(), []float64{0, 1, 2}; !slices.Equal(got, want) {
+			t.Errorf("rows %v, want %v", got, want)
+		}
+		if left := blobNames(t, src, "am-signinlogs"); len(left) != 0 {
+			t.Errorf("blobs %q were not deleted once settled", left)
+		}
+	})
+}
+
+func TestTailStopsOnFailedRows(t *testing.T) {
+	sources(t, func(t *testing.T, src source.Source) {
+		src = appendSource{src}
+
+		checkpoints := monitor.NewCheckpointStore("sentinel-sync-checkpoints")
+		if err := checkpoints.Ensure(context.Background(), src); err != nil {
+			t.Fatal(err)
+		}
+
+		window := time.Now().UTC().Truncate(5 * time.Minute)
+		name := blobName(window, 0)
+		data := rows(t, row(window, "n", 0), row(window, "n", 1))
+
+		ax := newFakeAxiom(t)
+		ax.reject = func(dataset string, row map[string]any) string {
+			if row["n"] == 1.0 {
+				return "bad row"
+			}
+			return ""
+		}
+
+		// the rejected row stops tailing the blob, keeping it where it was
+		seed(t, src, "am-signinlogs", name, data)
+		drain(t, src, ax.sink(t, ""), poll.WithTailing(checkpoints))
+		if got := len(ax.rows("SigninLogs")); got != 1 {
+			t.Fatalf("tailed %d rows, want 1", got)
+		}
+
+		var cp monitor.Checkpoint
+		if err := json.Unmarshal(readBlob(t, src, "sentinel-sync-checkpoints", "am-signinlogs.json"), &cp); err != nil {
+			t.Fatal(err)
+		}
+		if cp.Partial == nil || cp.Partial.Offset != 0 || !cp.Partial.Failed {
+			t.Fatalf("checkpoint %+v, want the blob to have failed at 0", cp.Partial)
+		}
+
+		// the blob grew, but isn't tailed again, so its rows aren't sent again
+		seed(t, src, "am-signinlogs", name, append(slices.Clip(data), rows(t, row(window, "n", 2))...))
+		drain(t, src, ax.sink(t, ""), poll.WithTailing(checkpoints))
+		if got := len(ax.rows("SigninLogs")); got != 1 {
+			t.Fatalf("tailed %d rows, want 1", got)
+		}
+
+		// once it settles it is shipped like any other blob
+		ax.reject = nil
+		settled := monitor.SettlePolicy{Grace: -time.Hour}
+		drain(t, src, ax.sink(t, ""), poll.WithTailing(checkpoints), poll.WithSettlePolicy(settled))
+		if got := len(ax.rows("SigninLogs")); got != 4 {
+			t.Errorf("ingested %d rows, want the 3 rows of the blob after the 1 that was tailed", got)
+		}
+		if left := blobNames(t, src, "am-signinlogs"); len(left) != 0 {
+			t.Errorf("blobs %q were not deleted once settled", left)
+		}
+	})
+}
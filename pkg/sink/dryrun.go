@@ -0,0 +1,175 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/axiomhq/axiom-go/axiom/ingest"
+	"github.com/axiomhq/sentinelexport/pkg/axm"
+)
+
+// DryRun reads and checks the rows instead of sending them anywhere, keeping a
+// report of what would have been sent to every dataset.
+type DryRun struct {
+	datasetPrefix string
+
+	mu       sync.Mutex
+	datasets map[string]*DryRunDataset
+}
+
+// DryRunDataset is what would have been sent to a dataset.
+type DryRunDataset struct {
+	Dataset string
+	Bytes   uint64
+	Rows    uint64
+	// Invalid rows aren't JSON objects, axiom would reject them.
+	Invalid uint64
+	// MissingTimestamp rows don't have the timestamp field, axiom would use the
+	// time they were ingested.
+	MissingTimestamp uint64
+	// BadTimestamp rows have a timestamp that doesn't parse with the timestamp
+	// format, or as RFC 3339 if there is none.
+	BadTimestamp uint64
+	// Oldest and Newest are the range of the timestamps that parsed.
+	Oldest time.Time
+	Newest time.Time
+}
+
+// NewDryRun reports the datasets by their name with the prefix added, as axm.Client
+// would ingest into them.
+func NewDryRun(datasetPrefix string) *DryRun {
+	return &DryRun{
+		datasetPrefix: datasetPrefix,
+		datasets:      map[string]*DryRunDataset{},
+	}
+}
+
+func (s *DryRun) Ensure(ctx context.Context, dataset *axm.Dataset) error {
+	return nil
+}
+
+func (s *DryRun) Write(ctx context.Context, dataset *axm.Dataset, open func() (io.ReadCloser, error)) (*ingest.Status, error) {
+	r, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	report := DryRunDataset{Dataset: s.datasetPrefix + dataset.Name()}
+	field, layout := dataset.TimestampFieldName(), dataset.TimestampFormat
+	if layout == "" {
+		layout = time.RFC3339Nano
+	}
+
+	br := bufio.NewReader(r)
+	for {
+		line, readErr := br.ReadBytes('\n')
+		report.Bytes += uint64(len(line))
+		if line := strings.TrimSpace(string(line)); line != "" {
+			report.row(line, field, layout)
+		}
+
+		if errors.Is(readErr, io.EOF) {
+			break
+		}
+		if readErr != nil {
+			return nil, fmt.Errorf("can not read rows: %w", readErr)
+		}
+	}
+
+	s.add(&report)
+	logger.Info("dry run", "dataset", report.Dataset, "rows", report.Rows, "invalid", report.Invalid, "missing_timestamp", report.MissingTimestamp, "bad_timestamp", report.BadTimestamp)
+
+	return &ingest.Status{
+		Ingested:       report.Rows - report.Invalid,
+		Failed:         report.Invalid,
+		ProcessedBytes: report.Bytes,
+	}, nil
+}
+
+func (r *DryRunDataset) row(line, field, layout string) {
+	r.Rows++
+
+	var row map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(line), &row); err != nil || row == nil {
+		r.Invalid++
+		return
+	}
+
+	raw, ok := row[field]
+	if !ok {
+		r.MissingTimestamp++
+		return
+	}
+
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		var epoch json.Number
+		if json.Unmarshal(raw, &epoch) != nil {
+			r.BadTimestamp++
+		}
+		// axiom takes numbers as unix time, they don't count towards the range
+		return
+	}
+
+	ts, err := time.Parse(layout, value)
+	if err != nil {
+		r.BadTimestamp++
+		return
+	}
+	r.observe(ts)
+}
+
+func (r *DryRunDataset) observe(ts time.Time) {
+	if r.Oldest.IsZero() || ts.Before(r.Oldest) {
+		r.Oldest = ts
+	}
+	if ts.After(r.Newest) {
+		r.Newest = ts
+	}
+}
+
+func (s *DryRun) add(report *DryRunDataset) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	total, ok := s.datasets[report.Dataset]
+	if !ok {
+		total = &DryRunDataset{Dataset: report.Dataset}
+		s.datasets[report.Dataset] = total
+	}
+
+	total.Bytes += report.Bytes
+	total.Rows += report.Rows
+	total.Invalid += report.Invalid
+	total.MissingTimestamp += report.MissingTimestamp
+	total.BadTimestamp += report.BadTimestamp
+	if !report.Oldest.IsZero() {
+		total.observe(report.Oldest)
+		total.observe(report.Newest)
+	}
+}
+
+// Report returns what would have been sent to every dataset, by dataset name.
+func (s *DryRun) Report() []DryRunDataset {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	report := make([]DryRunDataset, 0, len(s.datasets))
+	for _, ds := range s.datasets {
+		report = append(report, *ds)
+	}
+	slices.SortFunc(report, func(a, b DryRunDataset) int {
+		return strings.Compare(a.Dataset, b.Dataset)
+	})
+
+	return report
+}
@@ -0,0 +1,98 @@
+package sink
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/axiomhq/axiom-go/axiom/ingest"
+	"github.com/axiomhq/sentinelexport/pkg/axm"
+)
+
+// File appends the rows to newline delimited JSON files in a directory, one
+// file per dataset and day they were written on, e.g. SecurityEvent/2024-01-31.ndjson.
+type File struct {
+	dir string
+	now func() time.Time
+
+	// writes to the same file must not interleave
+	mu sync.Mutex
+}
+
+func NewFile(dir string) *File {
+	return &File{
+		dir: dir,
+		now: time.Now,
+	}
+}
+
+func (s *File) Ensure(ctx context.Context, dataset *axm.Dataset) error {
+	if err := os.MkdirAll(filepath.Join(s.dir, dataset.Name()), 0o755); err != nil {
+		return fmt.Errorf("can not create directory for dataset %q: %w", dataset.Name(), err)
+	}
+	return nil
+}
+
+func (s *File) Write(ctx context.Context, dataset *axm.Dataset, open func() (io.ReadCloser, error)) (*ingest.Status, error) {
+	r, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	name := filepath.Join(s.dir, dataset.Name(), s.now().UTC().Format(time.DateOnly)+".ndjson")
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("can not open %q: %w", name, err)
+	}
+
+	status, err := writeRows(f, r)
+	if closeErr := f.Close(); err == nil && closeErr != nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can not write %q: %w", name, err)
+	}
+
+	return status, nil
+}
+
+// writeRows copies the rows, making sure every one ends in a newline so the
+// next write starts on a line of its own.
+func writeRows(w io.Writer, r io.Reader) (*ingest.Status, error) {
+	var status ingest.Status
+
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+	for {
+		line, readErr := br.ReadBytes('\n')
+		if len(line) > 0 {
+			if line[len(line)-1] != '\n' {
+				line = append(line, '\n')
+			}
+			if _, err := bw.Write(line); err != nil {
+				return nil, err
+			}
+			status.Ingested++
+			status.ProcessedBytes += uint64(len(line))
+		}
+
+		if errors.Is(readErr, io.EOF) {
+			break
+		}
+		if readErr != nil {
+			return nil, readErr
+		}
+	}
+
+	return &status, bw.Flush()
+}
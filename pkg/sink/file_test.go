@@ -0,0 +1,96 @@
+package sink
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/axiomhq/sentinelexport/pkg/axm"
+)
+
+func rows(data string) func() (io.ReadCloser, error) {
+	return func() (io.ReadCloser, error) {
+		return io.NopCloser(strings.NewReader(data)), nil
+	}
+}
+
+func TestFileWritesRows(t *testing.T) {
+	dir := t.TempDir()
+	day := time.Date(2024, 1, 31, 23, 59, 0, 0, time.UTC)
+	s := NewFile(dir)
+	s.now = func() time.Time { return day }
+
+	ctx := context.Background()
+	dataset := axm.NewDataset("SigninLogs")
+	if err := s.Ensure(ctx, dataset); err != nil {
+		t.Fatal(err)
+	}
+
+	// the last row of a blob may not end in a newline, the next write must
+	// still start on a line of its own
+	status, err := s.Write(ctx, dataset, rows("{\"a\":1}\n{\"a\":2}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Ingested != 2 || status.Failed != 0 || status.ProcessedBytes != 16 {
+		t.Errorf("status is %+v", status)
+	}
+	if _, err := s.Write(ctx, dataset, rows("{\"a\":3}\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(filepath.Join(dir, "SigninLogs", "2024-01-31.ndjson"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "{\"a\":1}\n{\"a\":2}\n{\"a\":3}\n"; string(got) != want {
+		t.Errorf("wrote %q, want %q", got, want)
+	}
+}
+
+func TestFileRotatesDaily(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 31, 23, 59, 0, 0, time.UTC)
+	s := NewFile(dir)
+	s.now = func() time.Time { return now }
+
+	ctx := context.Background()
+	dataset := axm.NewDataset("SigninLogs")
+	if err := s.Ensure(ctx, dataset); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, row := range []string{"{\"a\":1}\n", "{\"a\":2}\n"} {
+		if _, err := s.Write(ctx, dataset, rows(row)); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(2 * time.Minute)
+	}
+
+	for name, want := range map[string]string{
+		"2024-01-31.ndjson": "{\"a\":1}\n",
+		"2024-02-01.ndjson": "{\"a\":2}\n",
+	} {
+		got, err := os.ReadFile(filepath.Join(dir, "SigninLogs", name))
+		if err != nil {
+			t.Error(err)
+			continue
+		}
+		if string(got) != want {
+			t.Errorf("%s is %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestFileWriteFails(t *testing.T) {
+	s := NewFile(t.TempDir())
+
+	// without Ensure there is no directory for the dataset
+	if _, err := s.Write(context.Background(), axm.NewDataset("SigninLogs"), rows("{}\n")); err == nil {
+		t.Error("wrote without a directory")
+	}
+}
@@ -0,0 +1,40 @@
+package sink
+
+import (
+	"context"
+	"io"
+
+	"github.com/axiomhq/axiom-go/axiom/ingest"
+	"github.com/axiomhq/sentinelexport/pkg/axm"
+	"github.com/axiomhq/sentinelexport/pkg/logging"
+)
+
+var logger = logging.New("sink")
+
+// Sink is where the rows of the blobs are sent to.
+type Sink interface {
+	// Ensure makes sure rows can be written to the dataset.
+	Ensure(ctx context.Context, dataset *axm.Dataset) error
+	// Write sends the newline delimited JSON rows to the dataset. open may be
+	// called again to retry, the reader it returns is closed by Write.
+	Write(ctx context.Context, dataset *axm.Dataset, open func() (io.ReadCloser, error)) (*ingest.Status, error)
+}
+
+// Axiom ingests the rows into axiom.
+type Axiom struct {
+	client *axm.Client
+}
+
+func NewAxiom(client *axm.Client) *Axiom {
+	return &Axiom{
+		client: client,
+	}
+}
+
+func (s *Axiom) Ensure(ctx context.Context, dataset *axm.Dataset) error {
+	return dataset.Ensure(ctx, s.client)
+}
+
+func (s *Axiom) Write(ctx context.Context, dataset *axm.Dataset, open func() (io.ReadCloser, error)) (*ingest.Status, error) {
+	return dataset.StreamWithRetry(ctx, s.client, open)
+}
@@ -0,0 +1,40 @@
+package sink
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/axiomhq/axiom-go/axiom/ingest"
+	"github.com/axiomhq/sentinelexport/pkg/axm"
+)
+
+// Stdout writes the rows to stdout.
+type Stdout struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewStdout() *Stdout {
+	return &Stdout{
+		w: os.Stdout,
+	}
+}
+
+func (s *Stdout) Ensure(ctx context.Context, dataset *axm.Dataset) error {
+	return nil
+}
+
+func (s *Stdout) Write(ctx context.Context, dataset *axm.Dataset, open func() (io.ReadCloser, error)) (*ingest.Status, error) {
+	r, err := open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return writeRows(s.w, r)
+}
@@ -0,0 +1,81 @@
+package sink
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/axiomhq/axiom-go/axiom/ingest"
+	"github.com/axiomhq/sentinelexport/pkg/axm"
+)
+
+// Tee writes the rows to every sink in turn, e.g. to archive them locally too.
+// The rows are read once and kept in memory for the sinks, and a row counts as
+// failed if any sink failed to write it.
+type Tee struct {
+	sinks []Sink
+}
+
+func NewTee(sinks ...Sink) *Tee {
+	if len(sinks) == 0 {
+		panic("tee needs at least one sink")
+	}
+
+	return &Tee{
+		sinks: sinks,
+	}
+}
+
+func (s *Tee) Ensure(ctx context.Context, dataset *axm.Dataset) error {
+	for _, sink := range s.sinks {
+		if err := sink.Ensure(ctx, dataset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Tee) Write(ctx context.Context, dataset *axm.Dataset, open func() (io.ReadCloser, error)) (*ingest.Status, error) {
+	// download the blob once rather than once per sink, and per retry
+	r, err := open()
+	if err != nil {
+		return nil, err
+	}
+	data, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return nil, fmt.Errorf("can not read rows: %w", err)
+	}
+
+	rows := func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(data)), nil
+	}
+
+	var merged *ingest.Status
+	for _, sink := range s.sinks {
+		status, err := sink.Write(ctx, dataset, rows)
+		if err != nil {
+			return nil, err
+		}
+		merged = mergeStatus(merged, status)
+	}
+	return merged, nil
+}
+
+// mergeStatus keeps the failures of whichever status has the most, as each
+// sink failed the rows on its own, and only counts the rows every sink wrote
+// as ingested.
+func mergeStatus(a, b *ingest.Status) *ingest.Status {
+	if a == nil {
+		return b
+	}
+	if b.Failed > a.Failed {
+		a, b = b, a
+	}
+
+	merged := *a
+	merged.Ingested = min(a.Ingested, b.Ingested)
+	merged.ProcessedBytes = max(a.ProcessedBytes, b.ProcessedBytes)
+	return &merged
+}
@@ -0,0 +1,135 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"io"
+	"slices"
+	"testing"
+
+	"github.com/axiomhq/axiom-go/axiom/ingest"
+	"github.com/axiomhq/sentinelexport/pkg/axm"
+)
+
+// fakeSink reads the rows, opening them as many times as a retrying sink would,
+// and answers with status or err.
+type fakeSink struct {
+	opens  int
+	status *ingest.Status
+	err    error
+
+	got []string
+}
+
+func (s *fakeSink) Ensure(ctx context.Context, dataset *axm.Dataset) error {
+	return nil
+}
+
+func (s *fakeSink) Write(ctx context.Context, dataset *axm.Dataset, open func() (io.ReadCloser, error)) (*ingest.Status, error) {
+	for i := 0; i < max(s.opens, 1); i++ {
+		r, err := open()
+		if err != nil {
+			return nil, err
+		}
+		data, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+		s.got = append(s.got, string(data))
+	}
+
+	if s.err != nil {
+		return nil, s.err
+	}
+	return s.status, nil
+}
+
+func TestTeeReadsOnce(t *testing.T) {
+	var opened int
+	open := func() (io.ReadCloser, error) {
+		opened++
+		return rows("{\"a\":1}\n")()
+	}
+
+	retrying := &fakeSink{opens: 3, status: &ingest.Status{Ingested: 1}}
+	other := &fakeSink{status: &ingest.Status{Ingested: 1}}
+	if _, err := NewTee(retrying, other).Write(context.Background(), axm.NewDataset("SigninLogs"), open); err != nil {
+		t.Fatal(err)
+	}
+
+	if opened != 1 {
+		t.Errorf("opened the rows %d times, want once", opened)
+	}
+	for _, s := range []*fakeSink{retrying, other} {
+		for _, got := range s.got {
+			if got != "{\"a\":1}\n" {
+				t.Errorf("sink got %q", got)
+			}
+		}
+	}
+	if len(retrying.got) != 3 || len(other.got) != 1 {
+		t.Errorf("sinks read the rows %d and %d times, want 3 and 1", len(retrying.got), len(other.got))
+	}
+}
+
+func TestTeeMergesStatuses(t *testing.T) {
+	failure := &ingest.Failure{Error: "invalid timestamp"}
+	tests := []struct {
+		name     string
+		statuses []*ingest.Status
+		want     ingest.Status
+	}{
+		{
+			"all ingested",
+			[]*ingest.Status{{Ingested: 2, ProcessedBytes: 20}, {Ingested: 2, ProcessedBytes: 16}},
+			ingest.Status{Ingested: 2, ProcessedBytes: 20},
+		},
+		{
+			"first sink failed rows",
+			[]*ingest.Status{{Ingested: 1, Failed: 1, Failures: []*ingest.Failure{failure}, ProcessedBytes: 20}, {Ingested: 2, ProcessedBytes: 16}},
+			ingest.Status{Ingested: 1, Failed: 1, Failures: []*ingest.Failure{failure}, ProcessedBytes: 20},
+		},
+		{
+			"last sink failed rows",
+			[]*ingest.Status{{Ingested: 2, ProcessedBytes: 16}, {Ingested: 0, Failed: 2, Failures: []*ingest.Failure{failure, failure}, ProcessedBytes: 20}},
+			ingest.Status{Ingested: 0, Failed: 2, Failures: []*ingest.Failure{failure, failure}, ProcessedBytes: 20},
+		},
+	}
+
+	for _, tt := range tests {
+		var sinks []Sink
+		for _, status := range tt.statuses {
+			sinks = append(sinks, &fakeSink{status: status})
+		}
+
+		got, err := NewTee(sinks...).Write(context.Background(), axm.NewDataset("SigninLogs"), rows("{}\n{}\n"))
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if got.Ingested != tt.want.Ingested || got.Failed != tt.want.Failed || got.ProcessedBytes != tt.want.ProcessedBytes || !slices.Equal(got.Failures, tt.want.Failures) {
+			t.Errorf("%s: status is %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestTeeFails(t *testing.T) {
+	errDown := errors.New("down")
+
+	for i := 0; i < 2; i++ {
+		sinks := []*fakeSink{{status: &ingest.Status{Ingested: 1}}, {status: &ingest.Status{Ingested: 1}}}
+		sinks[i].err = errDown
+
+		_, err := NewTee(sinks[0], sinks[1]).Write(context.Background(), axm.NewDataset("SigninLogs"), rows("{}\n"))
+		if !errors.Is(err, errDown) {
+			t.Errorf("sink %d failing returned %v", i, err)
+		}
+	}
+
+	errOpen := errors.New("can not download")
+	open := func() (io.ReadCloser, error) { return nil, errOpen }
+	if _, err := NewTee(&fakeSink{}).Write(context.Background(), axm.NewDataset("SigninLogs"), open); !errors.Is(err, errOpen) {
+		t.Errorf("failing to open returned %v", err)
+	}
+}
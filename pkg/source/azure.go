@@ -0,0 +1,163 @@
+package source
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+)
+
+// Azure reads the blobs from an azure storage account.
+type Azure struct {
+	client *azblob.Client
+}
+
+func NewAzure(client *azblob.Client) *Azure {
+	return &Azure{
+		client: client,
+	}
+}
+
+func (s *Azure) ListContainers(ctx context.Context, prefix string) (containers []string, err error) {
+	pager := s.client.NewListContainersPager(&azblob.ListContainersOptions{
+		Prefix: &prefix,
+	})
+
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("can not get next page: %w", err)
+		}
+
+		for _, item := range page.ContainerItems {
+			containers = append(containers, *item.Name)
+		}
+	}
+
+	return containers, nil
+}
+
+func (s *Azure) ListBlobs(ctx context.Context, container string) (blobs []*Properties, err error) {
+	pager := s.client.NewListBlobsFlatPager(container, nil)
+	for pager.More() {
+		page, err := pager.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("can not get next page: %w", err)
+		}
+
+		for _, item := range page.Segment.BlobItems {
+			props := &Properties{Name: *item.Name}
+			if item.Properties != nil {
+				setProperties(props, item.Properties.LastModified, item.Properties.BlobType, item.Properties.ContentLength)
+			}
+			blobs = append(blobs, props)
+		}
+	}
+
+	return blobs, nil
+}
+
+func (s *Azure) Properties(ctx context.Context, container, name string) (*Properties, error) {
+	resp, err := s.client.ServiceClient().NewContainerClient(container).NewBlobClient(name).GetProperties(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("can not get blob properties container=%q, name=%q: %w", container, name, notFound(err))
+	}
+
+	props := &Properties{Name: name}
+	setProperties(props, resp.LastModified, resp.BlobType, resp.ContentLength)
+	return props, nil
+}
+
+func (s *Azure) Open(ctx context.Context, container, name string, offset int64) (io.ReadCloser, error) {
+	var opts *azblob.DownloadStreamOptions
+	if offset > 0 {
+		opts = &azblob.DownloadStreamOptions{
+			Range: azblob.HTTPRange{Offset: offset},
+		}
+	}
+
+	resp, err := s.client.DownloadStream(ctx, container, name, opts)
+	if offset > 0 && bloberror.HasCode(err, bloberror.InvalidRange) {
+		// the offset is the end of the blob, there is nothing left to read
+		return io.NopCloser(bytes.NewReader(nil)), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("can not download blob container=%q, name=%q: %w", container, name, notFound(err))
+	}
+
+	return resp.Body, nil
+}
+
+func (s *Azure) Delete(ctx context.Context, container, name string) error {
+	_, err := s.client.DeleteBlob(ctx, container, name, nil)
+	if err != nil {
+		return fmt.Errorf("can not delete blob %q: %w", name, notFound(err))
+	}
+
+	return nil
+}
+
+func (s *Azure) CreateContainer(ctx context.Context, container string) error {
+	_, err := s.client.CreateContainer(ctx, container, nil)
+	if err != nil && !bloberror.HasCode(err, bloberror.ContainerAlreadyExists) {
+		return fmt.Errorf("can not create container %q: %w", container, err)
+	}
+
+	return nil
+}
+
+func (s *Azure) Upload(ctx context.Context, container, name string, r io.Reader, metadata map[string]string) error {
+	var meta map[string]*string
+	if len(metadata) > 0 {
+		meta = make(map[string]*string, len(metadata))
+		for k, v := range metadata {
+			meta[k] = to.Ptr(v)
+		}
+	}
+
+	var err error
+	if buf, ok := r.(*bytes.Reader); ok {
+		// small blobs like checkpoints are uploaded in a single request
+		body := make([]byte, buf.Len())
+		if _, err := io.ReadFull(buf, body); err != nil {
+			return err
+		}
+		_, err = s.client.UploadBuffer(ctx, container, name, body, &azblob.UploadBufferOptions{
+			Metadata: meta,
+		})
+	} else {
+		_, err = s.client.UploadStream(ctx, container, name, r, &azblob.UploadStreamOptions{
+			Metadata: meta,
+		})
+	}
+	if err != nil {
+		return fmt.Errorf("can not upload blob container=%q, name=%q: %w", container, name, err)
+	}
+
+	return nil
+}
+
+func setProperties(props *Properties, lastModified *time.Time, blobType *blob.BlobType, size *int64) {
+	if lastModified != nil {
+		props.LastModified = *lastModified
+	}
+	props.Append = blobType != nil && *blobType == blob.BlobTypeAppendBlob
+	if size != nil {
+		props.Size = *size
+	}
+}
+
+// notFound wraps ErrNotFound into errors for missing blobs and containers, so
+// callers don't need to know about azure error codes.
+func notFound(err error) error {
+	if bloberror.HasCode(err, bloberror.BlobNotFound, bloberror.ContainerNotFound) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return err
+}
@@ -0,0 +1,233 @@
+package source
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Dir reads the blobs from a local directory with a sub directory per container,
+// laid out the way azcopy downloads a storage account:
+//
+//	<dir>/am-securityevent/WorkspaceResourceId=/subscriptions/.../y=2024/m=01/d=31/h=10/m=05/PT05M.json
+//
+// Files are never appended to, so they are settled straight away.
+type Dir struct {
+	dir string
+}
+
+func NewDir(dir string) *Dir {
+	return &Dir{
+		dir: dir,
+	}
+}
+
+func (s *Dir) ListContainers(ctx context.Context, prefix string) (containers []string, err error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, fmt.Errorf("can not read directory %q: %w", s.dir, err)
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() && strings.HasPrefix(entry.Name(), prefix) {
+			containers = append(containers, entry.Name())
+		}
+	}
+
+	return containers, nil
+}
+
+func (s *Dir) ListBlobs(ctx context.Context, container string) (blobs []*Properties, err error) {
+	root, err := s.path(container, "")
+	if err != nil {
+		return nil, err
+	}
+
+	err = filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() || strings.HasSuffix(path, metadataSuffix) {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		name, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		blobs = append(blobs, &Properties{
+			Name:         filepath.ToSlash(name),
+			LastModified: info.ModTime(),
+			Size:         info.Size(),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("can not list directory %q: %w", root, notExist(err))
+	}
+
+	// listings are ordered by the full name
+	slices.SortFunc(blobs, func(a, b *Properties) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return blobs, nil
+}
+
+func (s *Dir) Properties(ctx context.Context, container, name string) (*Properties, error) {
+	path, err := s.path(container, name)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not stat file %q: %w", path, notExist(err))
+	}
+
+	return &Properties{
+		Name:         name,
+		LastModified: info.ModTime(),
+		Size:         info.Size(),
+	}, nil
+}
+
+func (s *Dir) Open(ctx context.Context, container, name string, offset int64) (io.ReadCloser, error) {
+	path, err := s.path(container, name)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("can not open file %q: %w", path, notExist(err))
+	}
+
+	if offset > 0 {
+		if _, err := f.Seek(offset, io.SeekStart); err != nil {
+			f.Close()
+			return nil, fmt.Errorf("can not seek file %q: %w", path, err)
+		}
+	}
+
+	return f, nil
+}
+
+func (s *Dir) Delete(ctx context.Context, container, name string) error {
+	path, err := s.path(container, name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("can not delete file %q: %w", path, notExist(err))
+	}
+	_ = os.Remove(path + metadataSuffix)
+
+	// remove the folders left empty, like deleting the last blob with a prefix
+	root, _ := s.path(container, "")
+	for dir := filepath.Dir(path); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+
+	return nil
+}
+
+func (s *Dir) CreateContainer(ctx context.Context, container string) error {
+	path, err := s.path(container, "")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(path, 0o755); err != nil {
+		return fmt.Errorf("can not create directory %q: %w", path, err)
+	}
+
+	return nil
+}
+
+// Upload writes the metadata, if any, next to the file as <name>.metadata.json.
+func (s *Dir) Upload(ctx context.Context, container, name string, r io.Reader, metadata map[string]string) error {
+	path, err := s.path(container, name)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return fmt.Errorf("can not create directory for file %q: %w", path, err)
+	}
+
+	// write to a temporary file first, so a checkpoint is never half written
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return fmt.Errorf("can not create file %q: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return fmt.Errorf("can not write file %q: %w", path, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("can not write file %q: %w", path, err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		return fmt.Errorf("can not write file %q: %w", path, err)
+	}
+
+	if len(metadata) == 0 {
+		return nil
+	}
+
+	body, err := json.Marshal(metadata)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+metadataSuffix, body, 0o644); err != nil {
+		return fmt.Errorf("can not write metadata of file %q: %w", path, err)
+	}
+
+	return nil
+}
+
+const metadataSuffix = ".metadata.json"
+
+// path returns the path of the blob, making sure names can't point outside of
+// the container's directory.
+func (s *Dir) path(container, name string) (string, error) {
+	if !filepath.IsLocal(container) || strings.ContainsAny(container, `/\`) {
+		return "", fmt.Errorf("invalid container name %q", container)
+	}
+	if name == "" {
+		return filepath.Join(s.dir, container), nil
+	}
+
+	name = filepath.FromSlash(name)
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("invalid blob name %q", name)
+	}
+
+	return filepath.Join(s.dir, container, name), nil
+}
+
+func notExist(err error) error {
+	if errors.Is(err, fs.ErrNotExist) {
+		return fmt.Errorf("%w: %w", ErrNotFound, err)
+	}
+	return err
+}
@@ -0,0 +1,40 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+)
+
+// ErrNotFound is returned when a blob or its container doesn't exist.
+var ErrNotFound = errors.New("not found")
+
+// Source is where the blobs Data Export writes are read from, and where
+// checkpoints and dead-letters are kept.
+type Source interface {
+	// ListContainers returns the names of the containers starting with prefix.
+	ListContainers(ctx context.Context, prefix string) ([]string, error)
+	// ListBlobs returns every blob in the container, ordered by name.
+	ListBlobs(ctx context.Context, container string) ([]*Properties, error)
+	// Properties returns the properties of a single blob.
+	Properties(ctx context.Context, container, name string) (*Properties, error)
+	// Open reads the blob starting at the byte offset.
+	Open(ctx context.Context, container, name string, offset int64) (io.ReadCloser, error)
+	// Delete removes the blob.
+	Delete(ctx context.Context, container, name string) error
+	// CreateContainer creates the container if it doesn't exist yet.
+	CreateContainer(ctx context.Context, container string) error
+	// Upload writes the blob, replacing it if it exists.
+	Upload(ctx context.Context, container, name string, r io.Reader, metadata map[string]string) error
+}
+
+// Properties are what is known about a blob without reading it.
+type Properties struct {
+	Name         string
+	LastModified time.Time
+	Size         int64
+	// Append is set for append blobs, the only blobs that change after they
+	// are written.
+	Append bool
+}
@@ -0,0 +1,98 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/axiomhq/sentinelexport/pkg/logging"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var logger = logging.New("tracing")
+
+// ServiceName is the service.name of the exporter's spans.
+const ServiceName = "sentinel-sync"
+
+// Setup exports spans over OTLP/HTTP to the collector at endpoint, e.g.
+// http://localhost:4318, to which /v1/traces is appended, and propagates the
+// trace context in HTTP headers. The returned func flushes the spans that are
+// left and stops exporting.
+func Setup(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid otlp endpoint %q, must be a url like http://localhost:4318", endpoint)
+	}
+
+	options := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(strings.TrimSuffix(u.Path, "/") + "/v1/traces"),
+	}
+	switch u.Scheme {
+	case "http":
+		options = append(options, otlptracehttp.WithInsecure())
+	case "https":
+	default:
+		return nil, fmt.Errorf("invalid otlp endpoint %q, must be http or https", endpoint)
+	}
+
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("can not create otlp exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", ServiceName),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("can not create resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	otel.SetErrorHandler(otel.ErrorHandlerFunc(func(err error) {
+		logger.Warn("tracing failed", "error", err)
+	}))
+
+	return provider.Shutdown, nil
+}
+
+// New returns the tracer of a package. Like logging.New, it traces through the
+// provider Setup sets, even when made before Setup is called.
+func New(pkg string) trace.Tracer {
+	return otel.Tracer("github.com/axiomhq/sentinelexport/pkg/" + pkg)
+}
+
+// Transport traces the requests made through base, and propagates the trace
+// context to the server.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	return otelhttp.NewTransport(base)
+}
+
+// Fail records err on the span, if there was one.
+func Fail(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// End ends the span, recording err on it if there was one.
+func End(span trace.Span, err error) {
+	Fail(span, err)
+	span.End()
+}
@@ -0,0 +1,186 @@
+package transform
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Transform is a single change made to every row of a table before it is
+// ingested. Exactly one of its fields is set.
+type Transform struct {
+	// Drop removes the fields.
+	Drop []string `yaml:"drop,omitempty"`
+	// Rename renames fields, from the key to the value.
+	Rename map[string]string `yaml:"rename,omitempty"`
+	// Set sets fields to a fixed value, overwriting them if they exist.
+	Set map[string]any `yaml:"set,omitempty"`
+	// Coalesce sets fields the row doesn't have to the first of the listed
+	// fields that it does have.
+	Coalesce map[string][]string `yaml:"coalesce,omitempty"`
+}
+
+func (t *Transform) Validate() error {
+	ops := 0
+	if t.Drop != nil {
+		ops++
+	}
+	if t.Rename != nil {
+		ops++
+	}
+	if t.Set != nil {
+		ops++
+	}
+	if t.Coalesce != nil {
+		ops++
+	}
+	if ops != 1 {
+		return errors.New("must have exactly one of drop, rename, set or coalesce")
+	}
+
+	for i, field := range t.Drop {
+		if field == "" {
+			return fmt.Errorf("drop[%d]: field can not be empty", i)
+		}
+	}
+	for from, to := range t.Rename {
+		if from == "" || to == "" {
+			return fmt.Errorf("rename.%s: field can not be empty", from)
+		}
+	}
+	for field, value := range t.Set {
+		if field == "" {
+			return errors.New("set: field can not be empty")
+		}
+		if _, err := json.Marshal(value); err != nil {
+			return fmt.Errorf("set.%s: %w", field, err)
+		}
+	}
+	for field, from := range t.Coalesce {
+		if field == "" {
+			return errors.New("coalesce: field can not be empty")
+		}
+		for i, f := range from {
+			if f == "" {
+				return fmt.Errorf("coalesce.%s[%d]: field can not be empty", field, i)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (t *Transform) apply(row map[string]json.RawMessage) error {
+	for _, field := range t.Drop {
+		delete(row, field)
+	}
+
+	for from, to := range t.Rename {
+		if value, ok := row[from]; ok {
+			delete(row, from)
+			row[to] = value
+		}
+	}
+
+	for field, value := range t.Set {
+		raw, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		row[field] = raw
+	}
+
+	for field, from := range t.Coalesce {
+		if _, ok := row[field]; ok {
+			continue
+		}
+		for _, f := range from {
+			if value, ok := row[f]; ok {
+				row[field] = value
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+// Rows applies the transforms in order to every row of the newline delimited
+// JSON. Lines that aren't JSON objects are kept as they are, for axiom to reject.
+func Rows(data []byte, transforms []Transform) ([]byte, error) {
+	if len(transforms) == 0 {
+		return data, nil
+	}
+
+	var out bytes.Buffer
+	if err := rows(&out, bytes.NewReader(data), transforms); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// NewReader is Rows for a stream, so a blob doesn't have to be read into memory.
+func NewReader(r io.ReadCloser, transforms []Transform) io.ReadCloser {
+	if len(transforms) == 0 {
+		return r
+	}
+
+	pr, pw := io.Pipe()
+	go func() {
+		defer r.Close()
+		pw.CloseWithError(rows(pw, r, transforms))
+	}()
+
+	return pr
+}
+
+func rows(w io.Writer, r io.Reader, transforms []Transform) error {
+	br := bufio.NewReader(r)
+	bw := bufio.NewWriter(w)
+
+	for {
+		line, readErr := br.ReadBytes('\n')
+		if len(line) > 0 {
+			out, err := row(line, transforms)
+			if err != nil {
+				return err
+			}
+			if _, err := bw.Write(out); err != nil {
+				return err
+			}
+		}
+		if errors.Is(readErr, io.EOF) {
+			return bw.Flush()
+		}
+		if readErr != nil {
+			return readErr
+		}
+	}
+}
+
+func row(line []byte, transforms []Transform) ([]byte, error) {
+	body := bytes.TrimRight(line, "\r\n")
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil || fields == nil {
+		return line, nil
+	}
+
+	for _, t := range transforms {
+		if err := t.apply(fields); err != nil {
+			return nil, err
+		}
+	}
+
+	out, err := json.Marshal(fields)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < len(line) {
+		out = append(out, '\n')
+	}
+	return out, nil
+}